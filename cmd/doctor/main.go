@@ -0,0 +1,61 @@
+/*
+ * Copyright 2018-2026 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/buildpacks/libcnb"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/doctor"
+)
+
+func main() {
+	format := flag.String("format", "text", "the format of the report, text or json")
+	timeout := flag.Duration("dial-timeout", 5*time.Second, "the timeout for connecting to each communication endpoint")
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unsupported format %s, must be one of text or json\n", *format)
+		os.Exit(2)
+	}
+
+	b, err := libcnb.NewBindingsFromEnvironment()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to read bindings from environment\n%s\n", err)
+		os.Exit(1)
+	}
+
+	r := doctor.Doctor{Bindings: b, DialTimeout: *timeout}.Run()
+
+	if *format == "json" {
+		err = r.WriteJSON(os.Stdout)
+	} else {
+		err = r.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write report\n%s\n", err)
+		os.Exit(1)
+	}
+
+	if r.Failed() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,269 @@
+/*
+ * Copyright 2018-2026 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package doctor
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buildpacks/libcnb"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+// Check is the outcome of a single diagnostic step.
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Report is the outcome of all diagnostic steps, in the order they ran.
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+func (r *Report) add(name string, status string, format string, a ...interface{}) {
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Message: fmt.Sprintf(format, a...)})
+}
+
+func (r *Report) fail(name string, err error) {
+	r.add(name, StatusFail, "%s", strings.ReplaceAll(err.Error(), "\n", ": "))
+}
+
+// Failed reports whether any check failed.
+func (r Report) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes the report in human-readable form, one check per line.
+func (r Report) WriteText(w io.Writer) error {
+	for _, c := range r.Checks {
+		if _, err := fmt.Fprintf(w, "[%s] %s: %s\n", strings.ToUpper(c.Status), c.Name, c.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the report as JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+// Doctor diagnoses the connectivity of OneAgent to the tenant. It resolves the binding the same way the helper does at
+// launch time and then checks the API URL, the API token scopes, the OneAgent metainfo, the connection info, the
+// reachability of each communication endpoint and the presence of the preload library.
+type Doctor struct {
+	Bindings libcnb.Bindings
+
+	// DialTimeout bounds each connection attempt to a communication endpoint.
+	DialTimeout time.Duration
+}
+
+// Run runs all checks. Checks that depend on a failed check are left out of the report.
+func (d Doctor) Run() Report {
+	var r Report
+	d.tenant(&r)
+	d.preloadLibrary(&r)
+	return r
+}
+
+func (d Doctor) tenant(r *Report) {
+	b, ok, err := dt.ResolveBinding(d.Bindings, "BPL")
+	if err != nil {
+		r.fail("binding", err)
+		return
+	} else if !ok {
+		r.add("binding", StatusFail, "no Dynatrace binding, $VCAP_SERVICES service or $BPL_DYNATRACE_API_TOKEN found")
+		return
+	} else if err := dt.ValidateBinding(b); err != nil {
+		r.fail("binding", err)
+		return
+	}
+	r.add("binding", StatusPass, "using binding %s", b.Name)
+
+	base, err := dt.BaseURI(b)
+	if err != nil {
+		r.fail("api-url", err)
+		return
+	}
+	r.add("api-url", StatusPass, "%s", base)
+
+	tc, err := dt.TLSConfig(b)
+	if err != nil {
+		r.fail("tls", err)
+		return
+	}
+
+	client, err := dt.NewClientFromEnvironment("BPL", 10, 30, tc)
+	if err != nil {
+		r.fail("client", err)
+		return
+	}
+
+	ua := userAgent()
+
+	var apiErr *dt.APIError
+	if err := dt.CheckTokenScopes(client, b, ua, dt.RequiredScopes); err == nil {
+		r.add("token-scopes", StatusPass, "token has the required scopes %s", strings.Join(dt.RequiredScopes, ", "))
+	} else if errors.As(err, &apiErr) && apiErr.StatusCode != http.StatusUnauthorized {
+		r.add("token-scopes", StatusWarn, "unable to look up token: %s", strings.ReplaceAll(err.Error(), "\n", ": "))
+	} else {
+		r.fail("token-scopes", err)
+	}
+
+	raw := struct {
+		LatestAgentVersion string `json:"latestAgentVersion"`
+	}{}
+	if err := client.GetJSON(fmt.Sprintf("%s/v1/deployment/installer/agent/unix/paas/latest/metainfo", base), dt.APIToken(b), ua, &raw); err != nil {
+		r.fail("metainfo", err)
+	} else {
+		r.add("metainfo", StatusPass, "latest OneAgent version is %s", raw.LatestAgentVersion)
+	}
+
+	zone := b.Secret["network-zone"]
+	if s, ok := os.LookupEnv("BPL_DYNATRACE_NETWORK_ZONE"); ok {
+		zone = s
+	}
+
+	c, err := dt.GetConnectionInfo(client, b, ua, zone)
+	if err != nil {
+		r.fail("connectioninfo", err)
+		return
+	} else if len(c.CommunicationEndpoints) == 0 {
+		r.add("connectioninfo", StatusFail, "tenant %s returned no communication endpoints", c.TenantUUID)
+		return
+	}
+	r.add("connectioninfo", StatusPass, "tenant %s with %d communication endpoints", c.TenantUUID, len(c.CommunicationEndpoints))
+
+	for _, e := range c.CommunicationEndpoints {
+		name := fmt.Sprintf("endpoint %s", e)
+		if err := d.dial(e, tc); err != nil {
+			r.fail(name, err)
+		} else {
+			r.add(name, StatusPass, "reachable")
+		}
+	}
+}
+
+// dial connects to the endpoint, completing a TLS handshake unless the endpoint uses plain http.
+func (d Doctor) dial(endpoint string, tc *tls.Config) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("unable to parse %s\n%w", endpoint, err)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	address := net.JoinHostPort(u.Hostname(), port)
+
+	dialer := &net.Dialer{Timeout: d.DialTimeout}
+
+	if u.Scheme == "http" {
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			return fmt.Errorf("unable to connect to %s\n%w", address, err)
+		}
+		return conn.Close()
+	}
+
+	config := &tls.Config{}
+	if tc != nil {
+		config = tc.Clone()
+	}
+	config.ServerName = u.Hostname()
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, config)
+	if err != nil {
+		return fmt.Errorf("unable to complete TLS handshake with %s\n%w", address, err)
+	}
+	return conn.Close()
+}
+
+// preloadLibrary checks that each liboneagentproc.so in $LD_PRELOAD exists or, if there is none, that one exists
+// within $BPI_DYNATRACE_AGENT_HOME.
+func (Doctor) preloadLibrary(r *Report) {
+	var libraries []string
+	for _, l := range strings.FieldsFunc(os.Getenv("LD_PRELOAD"), func(r rune) bool {
+		return r == ':' || r == ' '
+	}) {
+		if filepath.Base(l) == "liboneagentproc.so" {
+			libraries = append(libraries, l)
+		}
+	}
+
+	if len(libraries) == 0 {
+		home, ok := os.LookupEnv("BPI_DYNATRACE_AGENT_HOME")
+		if !ok {
+			r.add("preload-library", StatusSkip, "$LD_PRELOAD does not include liboneagentproc.so and $BPI_DYNATRACE_AGENT_HOME is not set")
+			return
+		}
+
+		l, err := dt.Agent{}.PreloadLibrary(home)
+		if err != nil {
+			r.fail("preload-library", err)
+			return
+		}
+		libraries = append(libraries, l)
+	}
+
+	for _, l := range libraries {
+		if _, err := os.Stat(l); err != nil {
+			r.fail("preload-library", err)
+		} else {
+			r.add("preload-library", StatusPass, "%s exists", l)
+		}
+	}
+}
+
+func userAgent() string {
+	id, version := os.Getenv("BPI_DYNATRACE_BUILDPACK_ID"), os.Getenv("BPI_DYNATRACE_BUILDPACK_VERSION")
+	if id == "" || version == "" {
+		return "dynatrace-doctor"
+	}
+	return fmt.Sprintf("%s/%s", id, version)
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2018-2026 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package doctor_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/doctor"
+)
+
+func testDoctor(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		ca     string
+		d      doctor.Doctor
+		server *ghttp.Server
+		closed string
+		scopes []string
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		server = ghttp.NewTLSServer()
+		ca = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.HTTPTestServer.Certificate().Raw}))
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		closed = fmt.Sprintf("http://%s/communication", l.Addr())
+		Expect(l.Close()).To(Succeed())
+
+		scopes = []string{"InstallerDownload"}
+		server.RouteToHandler("POST", "/api/v2/apiTokens/lookup", func(w http.ResponseWriter, r *http.Request) {
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"enabled": true, "scopes": scopes})(w, r)
+		})
+		server.RouteToHandler("GET", "/api/v1/deployment/installer/agent/unix/paas/latest/metainfo",
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"latestAgentVersion": "test-version"}))
+		server.RouteToHandler("GET", "/api/v1/deployment/installer/agent/connectioninfo", func(w http.ResponseWriter, r *http.Request) {
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"tenantUUID":             "test-tenant-uuid",
+				"tenantToken":            "test-tenant-token",
+				"communicationEndpoints": []string{fmt.Sprintf("%s/communication", server.URL()), closed},
+			})(w, r)
+		})
+
+		d = doctor.Doctor{
+			Bindings: libcnb.Bindings{
+				{
+					Name: "test-binding",
+					Type: "Dynatrace",
+					Secret: map[string]string{
+						"api-token": "test-api-token",
+						"api-url":   server.URL(),
+						"ca.crt":    ca,
+					},
+				},
+			},
+			DialTimeout: time.Second,
+		}
+
+		library := filepath.Join(t.TempDir(), "liboneagentproc.so")
+		Expect(os.WriteFile(library, []byte{}, 0644)).To(Succeed())
+		t.Setenv("LD_PRELOAD", library)
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("checks the tenant and the agent", func() {
+		r := d.Run()
+
+		Expect(r.Checks).To(HaveLen(8))
+		Expect(r.Checks[0]).To(Equal(doctor.Check{Name: "binding", Status: doctor.StatusPass, Message: "using binding test-binding"}))
+		Expect(r.Checks[1]).To(Equal(doctor.Check{Name: "api-url", Status: doctor.StatusPass, Message: fmt.Sprintf("%s/api", server.URL())}))
+		Expect(r.Checks[2].Name).To(Equal("token-scopes"))
+		Expect(r.Checks[2].Status).To(Equal(doctor.StatusPass))
+		Expect(r.Checks[3]).To(Equal(doctor.Check{Name: "metainfo", Status: doctor.StatusPass, Message: "latest OneAgent version is test-version"}))
+		Expect(r.Checks[4]).To(Equal(doctor.Check{Name: "connectioninfo", Status: doctor.StatusPass, Message: "tenant test-tenant-uuid with 2 communication endpoints"}))
+		Expect(r.Checks[5]).To(Equal(doctor.Check{Name: fmt.Sprintf("endpoint %s/communication", server.URL()), Status: doctor.StatusPass, Message: "reachable"}))
+		Expect(r.Checks[6].Name).To(Equal(fmt.Sprintf("endpoint %s", closed)))
+		Expect(r.Checks[6].Status).To(Equal(doctor.StatusFail))
+		Expect(r.Checks[6].Message).To(ContainSubstring("unable to connect to"))
+		Expect(r.Checks[7].Name).To(Equal("preload-library"))
+		Expect(r.Checks[7].Status).To(Equal(doctor.StatusPass))
+		Expect(r.Failed()).To(BeTrue())
+	})
+
+	it("reports missing scopes", func() {
+		scopes = []string{"DataExport"}
+
+		r := d.Run()
+		Expect(r.Checks[2]).To(Equal(doctor.Check{
+			Name:    "token-scopes",
+			Status:  doctor.StatusFail,
+			Message: "Dynatrace API token is missing the required scopes InstallerDownload",
+		}))
+	})
+
+	it("stops at an invalid binding", func() {
+		delete(d.Bindings[0].Secret, "api-token")
+
+		r := d.Run()
+		Expect(r.Checks).To(HaveLen(2))
+		Expect(r.Checks[0].Name).To(Equal("binding"))
+		Expect(r.Checks[0].Status).To(Equal(doctor.StatusFail))
+		Expect(r.Checks[1].Name).To(Equal("preload-library"))
+		Expect(server.ReceivedRequests()).To(BeEmpty())
+	})
+
+	it("fails on missing preload library", func() {
+		t.Setenv("LD_PRELOAD", "/does/not/exist/liboneagentproc.so")
+		d.Bindings = nil
+
+		r := d.Run()
+		Expect(r.Checks[1].Name).To(Equal("preload-library"))
+		Expect(r.Checks[1].Status).To(Equal(doctor.StatusFail))
+	})
+
+	it("skips preload library outside of an application image", func() {
+		t.Setenv("LD_PRELOAD", "")
+		d.Bindings = nil
+
+		r := d.Run()
+		Expect(r.Checks[1].Status).To(Equal(doctor.StatusSkip))
+	})
+
+	it("writes text and JSON", func() {
+		r := doctor.Report{Checks: []doctor.Check{
+			{Name: "test-name", Status: doctor.StatusWarn, Message: "test-message"},
+		}}
+
+		text := &bytes.Buffer{}
+		Expect(r.WriteText(text)).To(Succeed())
+		Expect(text.String()).To(Equal("[WARN] test-name: test-message\n"))
+
+		raw := &bytes.Buffer{}
+		Expect(r.WriteJSON(raw)).To(Succeed())
+
+		var decoded doctor.Report
+		Expect(json.Unmarshal(raw.Bytes(), &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(r))
+		Expect(r.Failed()).To(BeFalse())
+	})
+}
@@ -17,21 +17,55 @@
 package dt
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/buildpacks/libcnb"
+	"github.com/heroku/color"
 	"github.com/paketo-buildpacks/libpak"
 	"github.com/paketo-buildpacks/libpak/bard"
 	"github.com/paketo-buildpacks/libpak/crush"
+	"github.com/paketo-buildpacks/libpak/sbom"
+)
+
+// PrunedPathsFile is the file in the agent layer listing the paths removed by PruneUnused, one per line.
+const PrunedPathsFile = "pruned-paths.txt"
+
+// DigestFile is the file in the agent layer containing the SHA256 digest of the downloaded agent.
+const DigestFile = "oneagent.sha256"
+
+// DownloadTimeout is the minimum time an attempt to download the agent with a Client may take before it is aborted.
+var DownloadTimeout = 15 * time.Minute
+
+const (
+	InjectionPreload     = "ld-preload"
+	InjectionAgentPath   = "agentpath"
+	InjectionNodeOptions = "node-options"
 )
 
 type Agent struct {
+	Architecture     string
+	BOMMetadata      map[string]interface{}
 	BuildpackID      string
 	BuildpackVersion string
+	CACertificates   string
+	Client           *Client
+	ConnectionInfo   *ConnectionInfo
+	Injection        string
 	LayerContributor libpak.DependencyLayerContributor
 	Logger           bard.Logger
+	Musl             bool
+	ProcessTypes     []string
+	Prune            bool
+	Technologies     []string
 }
 
 func NewAgent(
@@ -52,6 +86,7 @@ func NewAgent(
 	)
 
 	return Agent{
+		BOMMetadata:      entry.Metadata,
 		BuildpackID:      info.ID,
 		BuildpackVersion: info.Version,
 		LayerContributor: contributor,
@@ -61,23 +96,376 @@ func NewAgent(
 func (a Agent) Contribute(layer libcnb.Layer) (libcnb.Layer, error) {
 	a.LayerContributor.Logger = a.Logger
 
-	return a.LayerContributor.Contribute(layer, func(artifact *os.File) (libcnb.Layer, error) {
+	var (
+		digest string
+		pruned []string
+	)
+	f := func(artifact *os.File) (libcnb.Layer, error) {
+		var err error
+		if digest, err = a.Verify(artifact); err != nil {
+			return libcnb.Layer{}, err
+		}
+
+		if err := a.writeSBOM(layer, digest); err != nil {
+			return libcnb.Layer{}, err
+		}
+
+		file := filepath.Join(layer.Path, DigestFile)
+		if err := os.WriteFile(file, []byte(digest), 0644); err != nil {
+			return libcnb.Layer{}, fmt.Errorf("unable to write %s\n%w", file, err)
+		}
+
 		a.Logger.Bodyf("Expanding to %s", layer.Path)
 
 		if err := crush.ExtractZip(artifact, layer.Path, 0); err != nil {
 			return libcnb.Layer{}, fmt.Errorf("unable to expand Dynatrace OneAgent\n%w", err)
 		}
 
-		layer.LaunchEnvironment.Default("BPI_DYNATRACE_BUILDPACK_ID", a.BuildpackID)
-		layer.LaunchEnvironment.Default("BPI_DYNATRACE_BUILDPACK_VERSION", a.BuildpackVersion)
-		layer.LaunchEnvironment.Default("DT_LOGSTREAM", "stdout")
-		layer.LaunchEnvironment.Appendf("DT_CUSTOM_PROP", " ", "CloudNativeBuildpackVersion=%s", a.BuildpackVersion)
-		layer.LaunchEnvironment.Prependf("LD_PRELOAD", string(os.PathListSeparator), "%s/agent/lib64/liboneagentproc.so", layer.Path)
+		if a.Prune {
+			var saved int64
+			if pruned, saved, err = a.PruneUnused(layer.Path); err != nil {
+				return libcnb.Layer{}, fmt.Errorf("unable to prune Dynatrace OneAgent\n%w", err)
+			}
+			a.Logger.Bodyf("Pruned %d unused paths, saving %d bytes", len(pruned), saved)
+
+			// the removed paths are kept in the layer rather than its metadata so that the layer can be reused
+			file := filepath.Join(layer.Path, PrunedPathsFile)
+			if err := os.WriteFile(file, []byte(strings.Join(pruned, "\n")), 0644); err != nil {
+				return libcnb.Layer{}, fmt.Errorf("unable to write %s\n%w", file, err)
+			}
+		}
+
+		if a.CACertificates != "" {
+			// the layer is read-only at launch, so the CA certificates are passed to OneAgent at build time
+			file := filepath.Join(layer.Path, "agent", "customkeys", "custom.pem")
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				return libcnb.Layer{}, fmt.Errorf("unable to create directory %s\n%w", filepath.Dir(file), err)
+			}
+			if err := os.WriteFile(file, []byte(a.CACertificates), 0644); err != nil {
+				return libcnb.Layer{}, fmt.Errorf("unable to write %s\n%w", file, err)
+			}
+		}
+
+		env := launchEnvironment{Environment: layer.LaunchEnvironment, ProcessTypes: a.ProcessTypes}
+		if len(a.ProcessTypes) > 0 {
+			a.Logger.Bodyf("Instrumenting process types %s", strings.Join(a.ProcessTypes, ", "))
+			layer.LaunchEnvironment.Default("BPI_DYNATRACE_PROCESS_TYPES", strings.Join(a.ProcessTypes, ","))
+		}
+
+		env.Default("BPI_DYNATRACE_BUILDPACK_ID", a.BuildpackID)
+		env.Default("BPI_DYNATRACE_BUILDPACK_VERSION", a.BuildpackVersion)
+		env.Default("BPI_DYNATRACE_AGENT_HOME", layer.Path)
+		env.Default("DT_LOGSTREAM", "stdout")
+		env.Appendf("DT_CUSTOM_PROP", " ", "CloudNativeBuildpackVersion=%s", a.BuildpackVersion)
+		if a.ConnectionInfo != nil {
+			for k, v := range a.ConnectionInfo.Environment() {
+				env.Default(k, v)
+			}
+		}
+
+		switch a.Injection {
+		case InjectionAgentPath:
+			loader, err := a.LoaderLibrary(layer.Path)
+			if err != nil {
+				return libcnb.Layer{}, err
+			}
+			env.Appendf("JAVA_TOOL_OPTIONS", " ", "-agentpath:%s", loader)
+		case InjectionNodeOptions:
+			loader, err := a.NodeLoader(layer.Path)
+			if err != nil {
+				return libcnb.Layer{}, err
+			}
+			env.Appendf("NODE_OPTIONS", " ", "--require %s", loader)
+		default:
+			preload, err := a.PreloadLibrary(layer.Path)
+			if err != nil {
+				return libcnb.Layer{}, err
+			}
+			env.Prepend("LD_PRELOAD", string(os.PathListSeparator), preload)
+		}
 
 		return layer, nil
+	}
+
+	var err error
+	if a.Client == nil || a.resolvedByCache() {
+		layer, err = a.LayerContributor.Contribute(layer, f)
+	} else {
+		layer, err = a.contributeWithClient(layer, f)
+	}
+	if err != nil {
+		return libcnb.Layer{}, err
+	}
+
+	// the digest and pruned paths are read from the layer when it is reused, as f is not called then
+	if digest == "" {
+		if b, err := os.ReadFile(filepath.Join(layer.Path, DigestFile)); err == nil {
+			digest = strings.TrimSpace(string(b))
+		}
+	}
+	if pruned == nil && a.Prune {
+		if b, err := os.ReadFile(filepath.Join(layer.Path, PrunedPathsFile)); err == nil && len(b) > 0 {
+			pruned = strings.Split(string(b), "\n")
+		}
+	}
+
+	// the digest is only recorded in the BOM as libpak reuses the layer only if its metadata equals the expected one
+	if digest != "" && a.BOMMetadata != nil {
+		a.BOMMetadata["sha256"] = digest
+	}
+
+	if pruned != nil && a.BOMMetadata != nil {
+		a.BOMMetadata["pruned"] = pruned
+	}
+
+	return layer, nil
+}
+
+// writeSBOM writes the Syft SBOM of the layer, recording the computed digest of the agent as checksum qualifier of its
+// PURL, as the trusted SHA256 of the dependency may not be known and Syft artifacts have no digest.
+func (a Agent) writeSBOM(layer libcnb.Layer, digest string) error {
+	dep := a.LayerContributor.Dependency
+	if dep.PURL != "" {
+		separator := "?"
+		if strings.Contains(dep.PURL, "?") {
+			separator = "&"
+		}
+		dep.PURL = fmt.Sprintf("%s%schecksum=sha256:%s", dep.PURL, separator, digest)
+	}
+
+	artifact, err := dep.AsSyftArtifact()
+	if err != nil {
+		return fmt.Errorf("unable to get SBOM artifact %s\n%w", dep.ID, err)
+	}
+
+	if err := sbom.NewSyftDependency(layer.Path, []sbom.SyftArtifact{artifact}).WriteTo(layer.SBOMPath(libcnb.SyftJSON)); err != nil {
+		return fmt.Errorf("unable to write SBOM\n%w", err)
+	}
+
+	return nil
+}
+
+// launchEnvironment writes launch environment variables for all process types or, if ProcessTypes is set, for those
+// process types only.
+type launchEnvironment struct {
+	Environment  libcnb.Environment
+	ProcessTypes []string
+}
+
+func (l launchEnvironment) Default(name string, a ...interface{}) {
+	if len(l.ProcessTypes) == 0 {
+		l.Environment.Default(name, a...)
+	}
+	for _, t := range l.ProcessTypes {
+		l.Environment.ProcessDefault(t, name, a...)
+	}
+}
+
+func (l launchEnvironment) Appendf(name string, delimiter string, format string, a ...interface{}) {
+	if len(l.ProcessTypes) == 0 {
+		l.Environment.Appendf(name, delimiter, format, a...)
+	}
+	for _, t := range l.ProcessTypes {
+		l.Environment.ProcessAppendf(t, name, delimiter, format, a...)
+	}
+}
+
+func (l launchEnvironment) Prepend(name string, delimiter string, a ...interface{}) {
+	if len(l.ProcessTypes) == 0 {
+		l.Environment.Prepend(name, delimiter, a...)
+	}
+	for _, t := range l.ProcessTypes {
+		l.Environment.ProcessPrepend(t, name, delimiter, a...)
+	}
+}
+
+// PreloadLibrary returns the liboneagentproc.so within the expanded agent matching the C library of the run image.
+// Multidistro agents contain libraries for both glibc and musl, which are told apart by musl in their path.
+func (a Agent) PreloadLibrary(root string) (string, error) {
+	return a.library(root, "liboneagentproc.so", filepath.Join("agent", "lib64"))
+}
+
+// LoaderLibrary returns the liboneagentloader.so within the expanded agent matching the C library of the run image,
+// which is passed to the JVM with -agentpath.
+func (a Agent) LoaderLibrary(root string) (string, error) {
+	return a.library(root, "liboneagentloader.so", filepath.Join("agent", "lib64"))
+}
+
+// NodeLoader returns the onenodeloader.js within the expanded agent, which is passed to Node.js with --require.
+func (a Agent) NodeLoader(root string) (string, error) {
+	return a.library(root, "onenodeloader.js", filepath.Join("agent", "bin", "any"))
+}
+
+// library returns the file named name within root, preferring the one matching the C library of the run image, and
+// defaults to name in the fallback directory, relative to root.
+func (a Agent) library(root string, name string, fallback string) (string, error) {
+	var candidates []string
+	if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == name {
+			candidates = append(candidates, path)
+		}
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("unable to search %s for %s\n%w", root, name, err)
+	}
+
+	for _, c := range candidates {
+		if strings.Contains(strings.ToLower(strings.TrimPrefix(c, root)), "musl") == a.Musl {
+			return c, nil
+		}
+	}
+
+	if len(candidates) > 0 {
+		return candidates[0], nil
+	}
+
+	return filepath.Join(root, fallback, name), nil
+}
+
+// Verify computes the SHA256 digest of the artifact and compares it to the trusted digest of the dependency, if one
+// is known. The artifact is rewound so that it can be read again.
+func (a Agent) Verify(artifact *os.File) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, artifact); err != nil {
+		return "", fmt.Errorf("unable to compute digest of %s\n%w", artifact.Name(), err)
+	}
+
+	if _, err := artifact.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("unable to rewind %s\n%w", artifact.Name(), err)
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	if expected := a.LayerContributor.Dependency.SHA256; expected == "" {
+		a.Logger.Bodyf("%s no trusted SHA256 for Dynatrace OneAgent, not verifying and recording computed digest %s",
+			color.New(color.FgYellow, color.Bold).Sprint("Warning:"), digest)
+	} else if expected != digest {
+		return "", fmt.Errorf("SHA256 mismatch for Dynatrace OneAgent: expected %s, got %s", expected, digest)
+	} else {
+		a.Logger.Bodyf("Verified SHA256 %s", digest)
+	}
+
+	return digest, nil
+}
+
+// ExpectMetadata adds a value to the metadata the existing layer is compared against, so that a change to it triggers a
+// fresh contribution. The dependency is moved under the dependency key the first time this is called.
+func (a *Agent) ExpectMetadata(key string, value interface{}) {
+	m, ok := a.LayerContributor.ExpectedMetadata.(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{"dependency": a.LayerContributor.ExpectedMetadata}
+		a.LayerContributor.ExpectedMetadata = m
+	}
+	m[key] = value
+}
+
+// resolvedByCache reports whether libpak provides the agent from a dependency mapping or its caches instead of
+// downloading it, in which case it is not downloaded with the Dynatrace client.
+func (a Agent) resolvedByCache() bool {
+	d := a.LayerContributor
+	sha256 := d.Dependency.SHA256
+	if sha256 == "" {
+		return false
+	}
+
+	if _, ok := d.DependencyCache.Mappings[sha256]; ok {
+		return true
+	}
+
+	for _, dir := range []string{d.DependencyCache.CachePath, d.DependencyCache.DownloadPath} {
+		if dir == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%s.toml", sha256))); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contributeWithClient mirrors libpak.DependencyLayerContributor.Contribute but downloads the agent with the Dynatrace
+// client, so that a custom CA and client certificate apply to the download and failures are returned as *APIError.
+func (a Agent) contributeWithClient(layer libcnb.Layer, f libpak.DependencyLayerFunc) (libcnb.Layer, error) {
+	d := a.LayerContributor
+
+	lc := libpak.NewLayerContributor(d.Name(), d.ExpectedMetadata, d.ExpectedTypes)
+	lc.Logger = a.Logger
+
+	return lc.Contribute(layer, func() (libcnb.Layer, error) {
+		artifact, err := a.download()
+		if err != nil {
+			return libcnb.Layer{}, fmt.Errorf("unable to get dependency %s\n%w", d.Dependency.Name, err)
+		}
+		defer os.Remove(artifact.Name())
+		defer artifact.Close()
+
+		return f(artifact)
 	})
 }
 
+func (a Agent) download() (*os.File, error) {
+	d := a.LayerContributor
+	uri := d.Dependency.URI
+
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create new GET request for %s\n%w", uri, err)
+	}
+
+	if d.DependencyCache.UserAgent != "" {
+		req.Header.Set("User-Agent", d.DependencyCache.UserAgent)
+	}
+
+	for _, m := range d.RequestModifierFuncs {
+		if req, err = m(req); err != nil {
+			return nil, fmt.Errorf("unable to modify request\n%w", err)
+		}
+	}
+
+	a.Logger.Bodyf("%s from %s", color.YellowString("Downloading"), req.URL.Redacted())
+
+	// the agent is large, so each attempt, including reading the body, gets at least DownloadTimeout
+	c := *a.Client
+	hc := *c.HTTPClient
+	if hc.Timeout < DownloadTimeout {
+		hc.Timeout = DownloadTimeout
+	}
+	c.HTTPClient = &hc
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to request %s\n%w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, NewAPIError(resp, strings.TrimPrefix(req.Header.Get("Authorization"), "Api-Token "))
+	}
+
+	out, err := os.CreateTemp(d.DependencyCache.DownloadPath, "dynatrace-oneagent-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary file\n%w", err)
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, fmt.Errorf("unable to copy from %s to %s\n%w", req.URL.Redacted(), out.Name(), err)
+	}
+
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, fmt.Errorf("unable to rewind %s\n%w", out.Name(), err)
+	}
+
+	return out, nil
+}
+
 func (a Agent) Name() string {
 	return a.LayerContributor.LayerName()
 }
@@ -17,16 +17,23 @@
 package dt_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/buildpacks/libcnb"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
 	"github.com/paketo-buildpacks/libpak"
+	"github.com/paketo-buildpacks/libpak/bard"
 	"github.com/sclevine/spec"
 
 	"github.com/paketo-buildpacks/dynatrace/v4/dt"
@@ -71,6 +78,7 @@ func testAgent(t *testing.T, context spec.G, it spec.S) {
 		Expect(filepath.Join(layer.Path, "fixture-marker")).To(BeARegularFile())
 		Expect(layer.LaunchEnvironment["BPI_DYNATRACE_BUILDPACK_ID.default"]).To(Equal("test-id"))
 		Expect(layer.LaunchEnvironment["BPI_DYNATRACE_BUILDPACK_VERSION.default"]).To(Equal("test-version"))
+		Expect(layer.LaunchEnvironment["BPI_DYNATRACE_AGENT_HOME.default"]).To(Equal(layer.Path))
 		Expect(layer.LaunchEnvironment["DT_LOGSTREAM.default"]).To(Equal("stdout"))
 		Expect(layer.LaunchEnvironment["DT_CUSTOM_PROP.delim"]).To(Equal(" "))
 		Expect(layer.LaunchEnvironment["DT_CUSTOM_PROP.append"]).To(Equal("CloudNativeBuildpackVersion=test-version"))
@@ -78,6 +86,384 @@ func testAgent(t *testing.T, context spec.G, it spec.S) {
 		Expect(layer.LaunchEnvironment["LD_PRELOAD.prepend"]).To(Equal(fmt.Sprintf("%s/agent/lib64/liboneagentproc.so", layer.Path)))
 	})
 
+	it("contributes CA certificates", func() {
+		dep := libpak.BuildpackDependency{
+			URI:    "https://localhost/stub-dynatrace-agent.zip",
+			SHA256: "1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1",
+		}
+		dc := libpak.DependencyCache{CachePath: "testdata"}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.CACertificates = "test-ca-certificates"
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.ReadFile(filepath.Join(layer.Path, "agent", "customkeys", "custom.pem"))).
+			To(Equal([]byte("test-ca-certificates")))
+	})
+
+	it("contributes agent with agentpath injection", func() {
+		dep := libpak.BuildpackDependency{
+			URI:    "https://localhost/stub-dynatrace-agent.zip",
+			SHA256: "1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1",
+		}
+		dc := libpak.DependencyCache{CachePath: "testdata"}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.Injection = dt.InjectionAgentPath
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(layer.LaunchEnvironment["JAVA_TOOL_OPTIONS.delim"]).To(Equal(" "))
+		Expect(layer.LaunchEnvironment["JAVA_TOOL_OPTIONS.append"]).To(Equal(fmt.Sprintf("-agentpath:%s/agent/lib64/liboneagentloader.so", layer.Path)))
+		Expect(layer.LaunchEnvironment).NotTo(HaveKey("LD_PRELOAD.prepend"))
+	})
+
+	it("contributes agent with node-options injection", func() {
+		dep := libpak.BuildpackDependency{
+			URI:    "https://localhost/stub-dynatrace-agent.zip",
+			SHA256: "1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1",
+		}
+		dc := libpak.DependencyCache{CachePath: "testdata"}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.Injection = dt.InjectionNodeOptions
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(layer.LaunchEnvironment["NODE_OPTIONS.delim"]).To(Equal(" "))
+		Expect(layer.LaunchEnvironment["NODE_OPTIONS.append"]).To(Equal(fmt.Sprintf("--require %s/agent/bin/any/onenodeloader.js", layer.Path)))
+		Expect(layer.LaunchEnvironment).NotTo(HaveKey("LD_PRELOAD.prepend"))
+	})
+
+	it("contributes agent for process types", func() {
+		dep := libpak.BuildpackDependency{
+			URI:    "https://localhost/stub-dynatrace-agent.zip",
+			SHA256: "1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1",
+		}
+		dc := libpak.DependencyCache{CachePath: "testdata"}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.ProcessTypes = []string{"api", "web"}
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(layer.LaunchEnvironment["BPI_DYNATRACE_PROCESS_TYPES.default"]).To(Equal("api,web"))
+		for _, t := range []string{"api", "web"} {
+			Expect(layer.LaunchEnvironment[fmt.Sprintf("%s/BPI_DYNATRACE_BUILDPACK_ID.default", t)]).To(Equal("test-id"))
+			Expect(layer.LaunchEnvironment[fmt.Sprintf("%s/DT_LOGSTREAM.default", t)]).To(Equal("stdout"))
+			Expect(layer.LaunchEnvironment[fmt.Sprintf("%s/DT_CUSTOM_PROP.append", t)]).To(Equal("CloudNativeBuildpackVersion=test-version"))
+			Expect(layer.LaunchEnvironment[fmt.Sprintf("%s/LD_PRELOAD.prepend", t)]).To(Equal(fmt.Sprintf("%s/agent/lib64/liboneagentproc.so", layer.Path)))
+		}
+		Expect(layer.LaunchEnvironment).NotTo(HaveKey("BPI_DYNATRACE_BUILDPACK_ID.default"))
+		Expect(layer.LaunchEnvironment).NotTo(HaveKey("DT_LOGSTREAM.default"))
+		Expect(layer.LaunchEnvironment).NotTo(HaveKey("LD_PRELOAD.prepend"))
+	})
+
+	it("finds Node.js loader in expanded agent", func() {
+		root := t.TempDir()
+		loader := filepath.Join(root, "agent", "bin", "1.291.57.20240501-120000", "any", "onenodeloader.js")
+		Expect(os.MkdirAll(filepath.Dir(loader), 0755)).To(Succeed())
+		Expect(os.WriteFile(loader, []byte{}, 0644)).To(Succeed())
+
+		Expect(dt.Agent{}.NodeLoader(root)).To(Equal(loader))
+	})
+
+	it("records computed digest without trusted SHA256", func() {
+		path, err := filepath.Abs("testdata/1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1/stub-dynatrace-agent.zip")
+		Expect(err).NotTo(HaveOccurred())
+
+		dep := libpak.BuildpackDependency{
+			ID:   "dynatrace-oneagent",
+			URI:  fmt.Sprintf("file://%s", path),
+			PURL: "pkg:generic/dynatrace-one-agent@test-version?arch=amd64",
+		}
+		dc := libpak.DependencyCache{DownloadPath: ctx.Layers.Path}
+
+		j, be := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(filepath.Join(layer.Path, "fixture-marker")).To(BeARegularFile())
+		Expect(layer.Metadata["sha256"]).To(BeEmpty())
+		Expect(be.Metadata["sha256"]).To(Equal("1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1"))
+		Expect(os.ReadFile(filepath.Join(layer.Path, dt.DigestFile))).
+			To(Equal([]byte("1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1")))
+		Expect(os.ReadFile(layer.SBOMPath(libcnb.SyftJSON))).
+			To(ContainSubstring("checksum=sha256:1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1"))
+	})
+
+	it("reuses layer without trusted SHA256", func() {
+		path, err := filepath.Abs("testdata/1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1/stub-dynatrace-agent.zip")
+		Expect(err).NotTo(HaveOccurred())
+
+		dep := libpak.BuildpackDependency{
+			ID:  "dynatrace-oneagent",
+			URI: fmt.Sprintf("file://%s", path),
+		}
+		dc := libpak.DependencyCache{DownloadPath: ctx.Layers.Path}
+
+		out := &bytes.Buffer{}
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.Logger = bard.NewLogger(out)
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(fmt.Sprintf("%s.toml", layer.Path), []byte{}, 0644)).To(Succeed())
+
+		out.Reset()
+		j, be := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.Logger = bard.NewLogger(out)
+		_, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("Reusing"))
+		Expect(out.String()).NotTo(ContainSubstring("Contributing"))
+		Expect(be.Metadata["sha256"]).To(Equal("1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1"))
+	})
+
+	it("records pruned paths in the layer and reuses it", func() {
+		path := filepath.Join(t.TempDir(), "dynatrace-agent.zip")
+		out, err := os.Create(path)
+		Expect(err).NotTo(HaveOccurred())
+		z := zip.NewWriter(out)
+		for _, name := range []string{"agent/lib/liboneagentproc.so", "agent/lib64/liboneagentproc.so"} {
+			w, err := z.Create(name)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = w.Write([]byte("test"))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(z.Close()).To(Succeed())
+		Expect(out.Close()).To(Succeed())
+
+		dep := libpak.BuildpackDependency{
+			ID:  "dynatrace-oneagent",
+			URI: fmt.Sprintf("file://%s", path),
+		}
+		dc := libpak.DependencyCache{DownloadPath: ctx.Layers.Path}
+
+		log := &bytes.Buffer{}
+		j, be := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.Logger = bard.NewLogger(log)
+		j.Prune = true
+		j.ExpectMetadata("prune", true)
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.ReadFile(filepath.Join(layer.Path, dt.PrunedPathsFile))).To(Equal([]byte("agent/lib")))
+		Expect(be.Metadata["pruned"]).To(Equal([]string{"agent/lib"}))
+		Expect(layer.Metadata).NotTo(HaveKey("pruned"))
+		Expect(os.WriteFile(fmt.Sprintf("%s.toml", layer.Path), []byte{}, 0644)).To(Succeed())
+
+		log.Reset()
+		_, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(log.String()).To(ContainSubstring("Reusing"))
+		Expect(log.String()).NotTo(ContainSubstring("Contributing"))
+	})
+
+	it("fails if artifact does not match trusted SHA256", func() {
+		cache := filepath.Join(ctx.Layers.Path, "cache")
+		Expect(os.MkdirAll(filepath.Join(cache, "0000000000000000000000000000000000000000000000000000000000000000"), 0755)).To(Succeed())
+
+		in, err := os.ReadFile("testdata/1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1/stub-dynatrace-agent.zip")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(cache, "0000000000000000000000000000000000000000000000000000000000000000", "stub-dynatrace-agent.zip"), in, 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(cache, "0000000000000000000000000000000000000000000000000000000000000000.toml"), []byte(`uri = "https://localhost/stub-dynatrace-agent.zip"
+sha256 = "0000000000000000000000000000000000000000000000000000000000000000"
+`), 0644)).To(Succeed())
+
+		dep := libpak.BuildpackDependency{
+			URI:    "https://localhost/stub-dynatrace-agent.zip",
+			SHA256: "0000000000000000000000000000000000000000000000000000000000000000",
+		}
+		dc := libpak.DependencyCache{CachePath: cache}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = j.Contribute(layer)
+		Expect(err).To(MatchError(ContainSubstring("SHA256 mismatch for Dynatrace OneAgent")))
+		Expect(filepath.Join(layer.Path, "fixture-marker")).NotTo(BeAnExistingFile())
+	})
+
+	it("downloads agent with Dynatrace client", func() {
+		RegisterTestingT(t)
+		server := ghttp.NewTLSServer()
+		defer server.Close()
+
+		in, err := os.ReadFile("testdata/1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1/stub-dynatrace-agent.zip")
+		Expect(err).NotTo(HaveOccurred())
+
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/stub-dynatrace-agent.zip"),
+			ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
+			ghttp.RespondWith(http.StatusOK, in),
+		))
+
+		ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.HTTPTestServer.Certificate().Raw}))
+		tc, err := dt.TLSConfig(libcnb.Binding{Secret: map[string]string{"ca.crt": ca}})
+		Expect(err).NotTo(HaveOccurred())
+		client := dt.NewClient(time.Second, 0, tc)
+
+		dep := libpak.BuildpackDependency{
+			ID:     "dynatrace-oneagent",
+			URI:    fmt.Sprintf("%s/stub-dynatrace-agent.zip", server.URL()),
+			SHA256: "1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1",
+		}
+		dc := libpak.DependencyCache{DownloadPath: ctx.Layers.Path}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.Client = &client
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(filepath.Join(layer.Path, "fixture-marker")).To(BeARegularFile())
+		Expect(layer.SBOMPath(libcnb.SyftJSON)).To(BeARegularFile())
+	})
+
+	it("aborts stalled download with Dynatrace client", func() {
+		RegisterTestingT(t)
+		server := ghttp.NewServer()
+		defer server.Close()
+
+		server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Second)
+		})
+
+		timeout := dt.DownloadTimeout
+		dt.DownloadTimeout = 100 * time.Millisecond
+		defer func() { dt.DownloadTimeout = timeout }()
+
+		client := dt.NewClient(10*time.Millisecond, 0, nil)
+
+		dep := libpak.BuildpackDependency{
+			ID:  "dynatrace-oneagent",
+			URI: fmt.Sprintf("%s/stub-dynatrace-agent.zip", server.URL()),
+		}
+		dc := libpak.DependencyCache{DownloadPath: ctx.Layers.Path}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.Client = &client
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		start := time.Now()
+		_, err = j.Contribute(layer)
+		Expect(err).To(MatchError(ContainSubstring("Client.Timeout")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	it("returns API error if download is rejected", func() {
+		RegisterTestingT(t)
+		server := ghttp.NewServer()
+		defer server.Close()
+
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/stub-dynatrace-agent.zip"),
+			ghttp.RespondWith(http.StatusForbidden, `{"error":{"code":403,"message":"Token test-api-token is missing required scope"}}`),
+		))
+
+		client := dt.NewClient(time.Second, 0, nil)
+
+		dep := libpak.BuildpackDependency{
+			ID:  "dynatrace-oneagent",
+			URI: fmt.Sprintf("%s/stub-dynatrace-agent.zip", server.URL()),
+		}
+		dc := libpak.DependencyCache{DownloadPath: ctx.Layers.Path}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.Client = &client
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = j.Contribute(layer)
+
+		var apiErr *dt.APIError
+		Expect(errors.As(err, &apiErr)).To(BeTrue())
+		Expect(apiErr.StatusCode).To(Equal(http.StatusForbidden))
+		Expect(apiErr.Message).To(Equal("Token [REDACTED] is missing required scope"))
+	})
+
+	it("contributes connection info", func() {
+		dep := libpak.BuildpackDependency{
+			URI:    "https://localhost/stub-dynatrace-agent.zip",
+			SHA256: "1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1",
+		}
+		dc := libpak.DependencyCache{CachePath: "testdata"}
+
+		j, _ := dt.NewAgent(dep, dc, "test-api-token", ctx.Buildpack.Info)
+		j.ConnectionInfo = &dt.ConnectionInfo{
+			TenantUUID:             "test-tenant-uuid",
+			TenantToken:            "test-tenant-token",
+			CommunicationEndpoints: []string{"test-communication-endpoint-1", "test-communication-endpoint-2"},
+			NetworkZone:            "test-zone",
+		}
+		j.ExpectMetadata("connection-info", j.ConnectionInfo.Digest())
+
+		layer, err := ctx.Layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		layer, err = j.Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(layer.LaunchEnvironment["DT_TENANT.default"]).To(Equal("test-tenant-uuid"))
+		Expect(layer.LaunchEnvironment["DT_TENANTTOKEN.default"]).To(Equal("test-tenant-token"))
+		Expect(layer.LaunchEnvironment["DT_CONNECTION_POINT.default"]).To(Equal("test-communication-endpoint-1;test-communication-endpoint-2"))
+		Expect(layer.LaunchEnvironment["DT_NETWORK_ZONE.default"]).To(Equal("test-zone"))
+		Expect(layer.Metadata).To(HaveKeyWithValue("connection-info", j.ConnectionInfo.Digest()))
+		Expect(layer.Metadata).To(HaveKey("dependency"))
+	})
+
+	context("PreloadLibrary", func() {
+		var root string
+
+		it.Before(func() {
+			root = t.TempDir()
+			for _, p := range []string{"agent/lib64", "agent/musl/lib64"} {
+				Expect(os.MkdirAll(filepath.Join(root, p), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(root, p, "liboneagentproc.so"), []byte{}, 0644)).To(Succeed())
+			}
+		})
+
+		it("selects glibc library", func() {
+			Expect(dt.Agent{}.PreloadLibrary(root)).To(Equal(filepath.Join(root, "agent/lib64/liboneagentproc.so")))
+		})
+
+		it("selects musl library", func() {
+			Expect(dt.Agent{Musl: true}.PreloadLibrary(root)).To(Equal(filepath.Join(root, "agent/musl/lib64/liboneagentproc.so")))
+		})
+
+		it("selects only library", func() {
+			Expect(os.RemoveAll(filepath.Join(root, "agent/musl"))).To(Succeed())
+			Expect(dt.Agent{Musl: true}.PreloadLibrary(root)).To(Equal(filepath.Join(root, "agent/lib64/liboneagentproc.so")))
+		})
+	})
+
 	it("modifies dependency request with Authorization header", func() {
 		dep := libpak.BuildpackDependency{
 			URI:    "https://localhost/stub-dynatrace-agent.zip",
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	ArchPolicyFail = "fail"
+	ArchPolicySkip = "skip"
+)
+
+// Architecture is a CPU architecture OneAgent is available for.
+type Architecture struct {
+
+	// Name is the canonical name of the architecture, as used by Go and in PURLs.
+	Name string
+
+	// Dynatrace is the value of the arch parameter of the Dynatrace deployment API.
+	Dynatrace string
+}
+
+// https://docs.dynatrace.com/docs/dynatrace-api/environment-api/deployment/oneagent/download-oneagent-version
+var architectures = map[string]Architecture{
+	"amd64":   {Name: "amd64", Dynatrace: "x86"},
+	"x86_64":  {Name: "amd64", Dynatrace: "x86"},
+	"x86-64":  {Name: "amd64", Dynatrace: "x86"},
+	"arm64":   {Name: "arm64", Dynatrace: "arm"},
+	"aarch64": {Name: "arm64", Dynatrace: "arm"},
+	"ppc64le": {Name: "ppc64le", Dynatrace: "ppcle"},
+	"s390x":   {Name: "s390x", Dynatrace: "s390"},
+}
+
+// ResolveArchitecture returns the architecture of the application image, read from $BP_ARCH and defaulting to the
+// architecture of the build. Architectures OneAgent is not available for return an error.
+func ResolveArchitecture() (Architecture, error) {
+	s, ok := os.LookupEnv("BP_ARCH")
+	if !ok {
+		s = runtime.GOARCH
+	}
+
+	if a, ok := architectures[strings.ToLower(s)]; ok {
+		return a, nil
+	}
+
+	var supported []string
+	for k := range architectures {
+		supported = append(supported, k)
+	}
+	sort.Strings(supported)
+
+	return Architecture{}, fmt.Errorf("Dynatrace OneAgent is not available for arch %s, supported arches are %s",
+		s, strings.Join(supported, ", "))
+}
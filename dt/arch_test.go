@@ -0,0 +1,56 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testArchitecture(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	for arch, expected := range map[string]dt.Architecture{
+		"amd64":   {Name: "amd64", Dynatrace: "x86"},
+		"x86_64":  {Name: "amd64", Dynatrace: "x86"},
+		"arm64":   {Name: "arm64", Dynatrace: "arm"},
+		"aarch64": {Name: "arm64", Dynatrace: "arm"},
+		"ppc64le": {Name: "ppc64le", Dynatrace: "ppcle"},
+		"s390x":   {Name: "s390x", Dynatrace: "s390"},
+	} {
+		arch, expected := arch, expected
+
+		it("resolves "+arch, func() {
+			t.Setenv("BP_ARCH", arch)
+
+			Expect(dt.ResolveArchitecture()).To(Equal(expected))
+		})
+	}
+
+	it("returns error for unsupported arch", func() {
+		t.Setenv("BP_ARCH", "riscv64")
+
+		_, err := dt.ResolveArchitecture()
+		Expect(err).To(MatchError(ContainSubstring("Dynatrace OneAgent is not available for arch riscv64")))
+	})
+}
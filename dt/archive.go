@@ -0,0 +1,109 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"github.com/buildpacks/libcnb"
+	"github.com/paketo-buildpacks/libpak"
+	"github.com/paketo-buildpacks/libpak/bindings"
+)
+
+const (
+	// AgentArchiveBindingType is the type of a binding that points at a pre-staged OneAgent archive.
+	AgentArchiveBindingType = "dynatrace-agent-archive"
+
+	// AgentArchiveFile is the name of the OneAgent zip within a pre-staged archive directory.
+	AgentArchiveFile = "oneagent.zip"
+
+	// AgentArchiveMetadataFile is the name of the metadata file within a pre-staged archive directory.
+	AgentArchiveMetadataFile = "metadata.toml"
+)
+
+// AgentArchive is a pre-staged OneAgent zip used instead of downloading the agent from the tenant.
+type AgentArchive struct {
+
+	// Path is the path to the OneAgent zip.
+	Path string `toml:"-"`
+
+	// Version is the version of the OneAgent in the zip.
+	Version string `toml:"version"`
+
+	// Includes are the technologies the zip was downloaded with.
+	Includes []string `toml:"includes"`
+
+	// SHA256 is the optional trusted digest of the zip.
+	SHA256 string `toml:"sha256"`
+}
+
+// ResolveAgentArchive returns the pre-staged OneAgent archive from a binding of type dynatrace-agent-archive or the
+// directory in $BP_DYNATRACE_AGENT_ARCHIVE. Either must contain an oneagent.zip and a metadata.toml.
+func ResolveAgentArchive(binds libcnb.Bindings, cr libpak.ConfigurationResolver) (AgentArchive, bool, error) {
+	b, ok, err := bindings.ResolveOne(binds, bindings.OfType(AgentArchiveBindingType))
+	if err != nil {
+		return AgentArchive{}, false, fmt.Errorf("unable to resolve binding %s\n%w", AgentArchiveBindingType, err)
+	}
+
+	dir := b.Path
+	if !ok {
+		if dir, ok = cr.Resolve("BP_DYNATRACE_AGENT_ARCHIVE"); !ok || dir == "" {
+			return AgentArchive{}, false, nil
+		}
+	}
+
+	var a AgentArchive
+	file := filepath.Join(dir, AgentArchiveMetadataFile)
+	if _, err := toml.DecodeFile(file, &a); err != nil {
+		return AgentArchive{}, false, fmt.Errorf("unable to decode %s\n%w", file, err)
+	}
+
+	if a.Version == "" {
+		return AgentArchive{}, false, fmt.Errorf("%s must specify a version", file)
+	}
+
+	a.Path = filepath.Join(dir, AgentArchiveFile)
+	if _, err := os.Stat(a.Path); err != nil {
+		return AgentArchive{}, false, fmt.Errorf("unable to find %s\n%w", a.Path, err)
+	}
+
+	return a, true, nil
+}
+
+// Missing returns the technologies in includes that the archive was not downloaded with.
+func (a AgentArchive) Missing(includes []string) []string {
+	available := make(map[string]bool, len(a.Includes))
+	for _, i := range a.Includes {
+		available[i] = true
+	}
+
+	if available["all"] {
+		return nil
+	}
+
+	var missing []string
+	for _, i := range includes {
+		if !available[i] {
+			missing = append(missing, i)
+		}
+	}
+
+	return missing
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/paketo-buildpacks/libpak"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testAgentArchive(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		cr   libpak.ConfigurationResolver
+		path string
+	)
+
+	it.Before(func() {
+		path = t.TempDir()
+		Expect(os.WriteFile(filepath.Join(path, "oneagent.zip"), []byte{}, 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(path, "metadata.toml"), []byte(`version = "1.291.57.20240501-120000"
+includes = ["java", "php"]
+`), 0644)).To(Succeed())
+	})
+
+	it("does not resolve without binding or $BP_DYNATRACE_AGENT_ARCHIVE", func() {
+		_, ok, err := dt.ResolveAgentArchive(libcnb.Bindings{}, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	it("resolves from binding", func() {
+		a, ok, err := dt.ResolveAgentArchive(libcnb.Bindings{
+			{Name: "dynatrace-archive", Type: "dynatrace-agent-archive", Path: path},
+		}, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(a).To(Equal(dt.AgentArchive{
+			Path:     filepath.Join(path, "oneagent.zip"),
+			Version:  "1.291.57.20240501-120000",
+			Includes: []string{"java", "php"},
+		}))
+	})
+
+	it("resolves from $BP_DYNATRACE_AGENT_ARCHIVE", func() {
+		t.Setenv("BP_DYNATRACE_AGENT_ARCHIVE", path)
+
+		a, ok, err := dt.ResolveAgentArchive(libcnb.Bindings{}, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(a.Version).To(Equal("1.291.57.20240501-120000"))
+	})
+
+	it("fails without version", func() {
+		Expect(os.WriteFile(filepath.Join(path, "metadata.toml"), []byte(`includes = ["java"]`), 0644)).To(Succeed())
+		t.Setenv("BP_DYNATRACE_AGENT_ARCHIVE", path)
+
+		_, _, err := dt.ResolveAgentArchive(libcnb.Bindings{}, cr)
+		Expect(err).To(MatchError(ContainSubstring("must specify a version")))
+	})
+
+	it("fails without zip", func() {
+		Expect(os.Remove(filepath.Join(path, "oneagent.zip"))).To(Succeed())
+		t.Setenv("BP_DYNATRACE_AGENT_ARCHIVE", path)
+
+		_, _, err := dt.ResolveAgentArchive(libcnb.Bindings{}, cr)
+		Expect(err).To(MatchError(ContainSubstring("unable to find")))
+	})
+
+	it("reports missing technologies", func() {
+		a := dt.AgentArchive{Includes: []string{"java"}}
+		Expect(a.Missing([]string{"java", "php"})).To(Equal([]string{"php"}))
+		Expect(dt.AgentArchive{Includes: []string{"all"}}.Missing([]string{"java", "php"})).To(BeEmpty())
+	})
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+	"github.com/paketo-buildpacks/libpak/bindings"
+)
+
+// ResolveBinding returns the Dynatrace binding. If $<prefix>_DYNATRACE_BINDING_NAME is set, the binding or Cloud
+// Foundry service with that name is returned regardless of its type. Otherwise, the single binding matching
+// IsDynatraceBinding, or failing that the single Dynatrace service in $VCAP_SERVICES, or failing that the binding
+// synthesized from the environment by EnvironmentBinding, is returned and an error listing the candidates if more than
+// one matches.
+func ResolveBinding(binds libcnb.Bindings, prefix string) (libcnb.Binding, bool, error) {
+	variable := fmt.Sprintf("%s_DYNATRACE_BINDING_NAME", prefix)
+
+	services, err := VCAPServicesBindings()
+	if err != nil {
+		return libcnb.Binding{}, false, err
+	}
+
+	if name, ok := os.LookupEnv(variable); ok && name != "" {
+		for _, b := range append(append(libcnb.Bindings{}, binds...), services...) {
+			if b.Name == name && !bindings.OfType(AgentArchiveBindingType)(b) {
+				return b, true, nil
+			}
+		}
+
+		return libcnb.Binding{}, false, fmt.Errorf("no binding named %s found for $%s, candidates are: %s",
+			name, variable, bindingNames(append(bindings.Resolve(binds, IsDynatraceBinding), services...)))
+	}
+
+	candidates := bindings.Resolve(binds, IsDynatraceBinding)
+	if len(candidates) == 0 {
+		candidates = services
+	}
+	if len(candidates) == 0 {
+		if b, ok := EnvironmentBinding(prefix); ok {
+			return b, true, nil
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return libcnb.Binding{}, false, nil
+	case 1:
+		return candidates[0], true, nil
+	default:
+		return libcnb.Binding{}, false, fmt.Errorf("multiple Dynatrace bindings found: %s, select one with $%s",
+			bindingNames(candidates), variable)
+	}
+}
+
+// EnvironmentBinding synthesizes a binding of type Dynatrace from $<prefix>_DYNATRACE_API_URL,
+// $<prefix>_DYNATRACE_ENVIRONMENT_ID, $<prefix>_DYNATRACE_API_TOKEN and $<prefix>_DYNATRACE_CA_CERT, for platforms that
+// cannot mount bindings. It returns false unless the API token and either the API URL or environment id are set.
+func EnvironmentBinding(prefix string) (libcnb.Binding, bool) {
+	secret := make(map[string]string)
+	for k, v := range map[string]string{
+		"api-url":        "API_URL",
+		"environment-id": "ENVIRONMENT_ID",
+		"api-token":      "API_TOKEN",
+		CACertificateKey: "CA_CERT",
+	} {
+		if s := strings.TrimSpace(os.Getenv(fmt.Sprintf("%s_DYNATRACE_%s", prefix, v))); s != "" {
+			secret[k] = s
+		}
+	}
+
+	if secret["api-token"] == "" || (secret["api-url"] == "" && secret["environment-id"] == "") {
+		return libcnb.Binding{}, false
+	}
+
+	return libcnb.Binding{
+		Name:   "environment",
+		Type:   "Dynatrace",
+		Secret: secret,
+	}, true
+}
+
+func bindingNames(binds libcnb.Bindings) string {
+	if len(binds) == 0 {
+		return "none"
+	}
+
+	var names []string
+	for _, b := range binds {
+		names = append(names, b.Name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
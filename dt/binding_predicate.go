@@ -8,6 +8,10 @@ import (
 )
 
 func IsDynatraceBinding(bind libcnb.Binding) bool {
+	if bindings.OfType(AgentArchiveBindingType)(bind) {
+		return false
+	}
+
 	if bindings.OfType("Dynatrace")(bind) {
 		return true
 	}
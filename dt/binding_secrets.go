@@ -18,18 +18,72 @@ package dt
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/buildpacks/libcnb"
 )
 
-func BaseURI(binding libcnb.Binding) string {
+// BaseURI returns the normalized base URI of the Dynatrace API. It is taken from api-url (which may reference
+// {environment-id}) or built from environment-id and either managed-domain, for Dynatrace Managed clusters, or
+// saas-domain, defaulting to live.dynatrace.com.
+func BaseURI(binding libcnb.Binding) (string, error) {
+	var uri string
+
 	if s, ok := binding.Secret["api-url"]; ok {
-		return s
+		uri = s
+	} else if s, ok := binding.Secret["apiurl"]; ok {
+		uri = s
+	} else if d, ok := binding.Secret["managed-domain"]; ok {
+		uri = fmt.Sprintf("%s/e/{environment-id}/api", withScheme(d))
+	} else if d, ok := binding.Secret["saas-domain"]; ok {
+		uri = fmt.Sprintf("https://{environment-id}.%s/api", strings.Trim(d, "./"))
+	} else {
+		uri = "https://{environment-id}.live.dynatrace.com/api"
 	}
-	if s, ok := binding.Secret["apiurl"]; ok {
-		return s
+
+	if strings.Contains(uri, "{environment-id}") {
+		id := strings.TrimSpace(binding.Secret["environment-id"])
+		if id == "" {
+			return "", fmt.Errorf("environment-id must be set to build Dynatrace API URL %s", uri)
+		}
+		uri = strings.ReplaceAll(uri, "{environment-id}", id)
+	}
+
+	return normalizeBaseURI(uri)
+}
+
+func withScheme(domain string) string {
+	domain = strings.TrimRight(strings.TrimSpace(domain), "/")
+	if strings.Contains(domain, "://") {
+		return domain
+	}
+	return fmt.Sprintf("https://%s", domain)
+}
+
+func normalizeBaseURI(uri string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse Dynatrace API URL %s\n%w", uri, err)
 	}
-	return fmt.Sprintf("https://%s.live.dynatrace.com/api", binding.Secret["environment-id"])
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("unable to use Dynatrace API URL %s, scheme must be http or https", uri)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("unable to use Dynatrace API URL %s, host must be set", uri)
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	if !strings.HasSuffix(u.Path, "/api") {
+		u.Path = fmt.Sprintf("%s/api", u.Path)
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return u.String(), nil
 }
 
 func APIToken(binding libcnb.Binding) string {
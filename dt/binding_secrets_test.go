@@ -42,24 +42,69 @@ func testBaseURI(t *testing.T, _ spec.G, it spec.S) {
 	)
 
 	it("uses api-url", func() {
-		Expect(dt.BaseURI(createBinding("api-url", "test-url"))).
-			To(Equal("test-url"))
+		Expect(dt.BaseURI(createBinding("api-url", "https://test-url/api"))).
+			To(Equal("https://test-url/api"))
 	})
 
 	it("uses api-url when both api-url and apiurl are set", func() {
-		Expect(dt.BaseURI(createBinding("api-url", "test-url", "apiurl", "other-url"))).
-			To(Equal("test-url"))
+		Expect(dt.BaseURI(createBinding("api-url", "https://test-url/api", "apiurl", "https://other-url/api"))).
+			To(Equal("https://test-url/api"))
 	})
 
 	it("uses apiurl", func() {
-		Expect(dt.BaseURI(createBinding("apiurl", "other-url"))).
-			To(Equal("other-url"))
+		Expect(dt.BaseURI(createBinding("apiurl", "https://other-url/api"))).
+			To(Equal("https://other-url/api"))
 	})
 
 	it("uses environment-id", func() {
 		Expect(dt.BaseURI(createBinding("environment-id", "test-id"))).
 			To(Equal("https://test-id.live.dynatrace.com/api"))
 	})
+
+	it("uses managed-domain", func() {
+		Expect(dt.BaseURI(createBinding("environment-id", "test-id", "managed-domain", "dynatrace.example.com"))).
+			To(Equal("https://dynatrace.example.com/e/test-id/api"))
+	})
+
+	it("uses managed-domain with scheme", func() {
+		Expect(dt.BaseURI(createBinding("environment-id", "test-id", "managed-domain", "https://dynatrace.example.com:9999/"))).
+			To(Equal("https://dynatrace.example.com:9999/e/test-id/api"))
+	})
+
+	it("uses saas-domain", func() {
+		Expect(dt.BaseURI(createBinding("environment-id", "test-id", "saas-domain", "sprint.dynatracelabs.com"))).
+			To(Equal("https://test-id.sprint.dynatracelabs.com/api"))
+	})
+
+	it("uses templated api-url", func() {
+		Expect(dt.BaseURI(createBinding("environment-id", "test-id", "api-url", "https://activegate.example.com:9999/e/{environment-id}/api"))).
+			To(Equal("https://activegate.example.com:9999/e/test-id/api"))
+	})
+
+	it("removes trailing slashes", func() {
+		Expect(dt.BaseURI(createBinding("api-url", "https://test-url/api//"))).
+			To(Equal("https://test-url/api"))
+	})
+
+	it("appends missing /api", func() {
+		Expect(dt.BaseURI(createBinding("api-url", "https://test-id.live.dynatrace.com/"))).
+			To(Equal("https://test-id.live.dynatrace.com/api"))
+	})
+
+	it("fails without environment-id", func() {
+		_, err := dt.BaseURI(createBinding("managed-domain", "dynatrace.example.com"))
+		Expect(err).To(MatchError(ContainSubstring("environment-id must be set")))
+	})
+
+	it("fails without scheme", func() {
+		_, err := dt.BaseURI(createBinding("api-url", "test-url"))
+		Expect(err).To(MatchError(ContainSubstring("scheme must be http or https")))
+	})
+
+	it("fails without host", func() {
+		_, err := dt.BaseURI(createBinding("api-url", "https:///api"))
+		Expect(err).To(MatchError(ContainSubstring("host must be set")))
+	})
 }
 
 func testAPIToken(t *testing.T, _ spec.G, it spec.S) {
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testResolveBinding(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		binds = libcnb.Bindings{
+			{Name: "dynatrace-config", Type: "user-provided"},
+			{Name: "team-credentials", Type: "Dynatrace"},
+			{Name: "other", Type: "user-provided"},
+			{Name: "dynatrace-archive", Type: dt.AgentArchiveBindingType},
+		}
+	)
+
+	it("returns false without binding", func() {
+		_, ok, err := dt.ResolveBinding(libcnb.Bindings{{Name: "other", Type: "user-provided"}}, "BP")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	it("returns single binding", func() {
+		b, ok, err := dt.ResolveBinding(binds[1:], "BP")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(b.Name).To(Equal("team-credentials"))
+	})
+
+	it("returns error listing candidates for multiple bindings", func() {
+		_, _, err := dt.ResolveBinding(binds, "BP")
+		Expect(err).To(MatchError("multiple Dynatrace bindings found: dynatrace-config, team-credentials, select one with $BP_DYNATRACE_BINDING_NAME"))
+	})
+
+	context("$VCAP_SERVICES", func() {
+		it.Before(func() {
+			t.Setenv("VCAP_SERVICES", `{"dynatrace": [{"name": "test-service", "credentials": {"environmentid": "test-id", "apitoken": "test-api-token"}}]}`)
+		})
+
+		it("returns Dynatrace service without binding", func() {
+			b, ok, err := dt.ResolveBinding(libcnb.Bindings{}, "BP")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(b.Name).To(Equal("test-service"))
+			Expect(b.Secret).To(HaveKeyWithValue("environment-id", "test-id"))
+		})
+
+		it("prefers bindings", func() {
+			b, ok, err := dt.ResolveBinding(binds[1:], "BP")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(b.Name).To(Equal("team-credentials"))
+		})
+
+		it("returns named Dynatrace service", func() {
+			t.Setenv("BP_DYNATRACE_BINDING_NAME", "test-service")
+
+			b, ok, err := dt.ResolveBinding(binds, "BP")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(b.Name).To(Equal("test-service"))
+		})
+	})
+
+	context("environment", func() {
+		it.Before(func() {
+			t.Setenv("BP_DYNATRACE_API_URL", "https://test.example.com/api")
+			t.Setenv("BP_DYNATRACE_API_TOKEN", "test-api-token")
+		})
+
+		it("synthesizes binding without binding", func() {
+			b, ok, err := dt.ResolveBinding(libcnb.Bindings{}, "BP")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(b).To(Equal(libcnb.Binding{
+				Name: "environment",
+				Type: "Dynatrace",
+				Secret: map[string]string{
+					"api-url":   "https://test.example.com/api",
+					"api-token": "test-api-token",
+				},
+			}))
+		})
+
+		it("prefers bindings", func() {
+			b, _, err := dt.ResolveBinding(binds[1:], "BP")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.Name).To(Equal("team-credentials"))
+		})
+
+		it("uses prefix", func() {
+			_, ok, err := dt.ResolveBinding(libcnb.Bindings{}, "BPL")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+
+		it("requires API token", func() {
+			t.Setenv("BP_DYNATRACE_API_TOKEN", "")
+
+			_, ok := dt.EnvironmentBinding("BP")
+			Expect(ok).To(BeFalse())
+		})
+
+		it("reads CA certificates", func() {
+			t.Setenv("BP_DYNATRACE_CA_CERT", "test-ca-certificates")
+
+			b, ok := dt.EnvironmentBinding("BP")
+			Expect(ok).To(BeTrue())
+			Expect(b.Secret).To(HaveKeyWithValue("ca.crt", "test-ca-certificates"))
+		})
+
+		it("accepts environment id", func() {
+			t.Setenv("BP_DYNATRACE_API_URL", "")
+			t.Setenv("BP_DYNATRACE_ENVIRONMENT_ID", "test-id")
+
+			b, ok := dt.EnvironmentBinding("BP")
+			Expect(ok).To(BeTrue())
+			Expect(dt.BaseURI(b)).To(Equal("https://test-id.live.dynatrace.com/api"))
+		})
+	})
+
+	context("$BP_DYNATRACE_BINDING_NAME", func() {
+		it("returns named binding", func() {
+			t.Setenv("BP_DYNATRACE_BINDING_NAME", "team-credentials")
+
+			b, ok, err := dt.ResolveBinding(binds, "BP")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(b.Name).To(Equal("team-credentials"))
+		})
+
+		it("returns named binding regardless of type", func() {
+			t.Setenv("BP_DYNATRACE_BINDING_NAME", "other")
+
+			b, ok, err := dt.ResolveBinding(binds, "BP")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(b.Name).To(Equal("other"))
+		})
+
+		it("returns error listing candidates for unknown name", func() {
+			t.Setenv("BP_DYNATRACE_BINDING_NAME", "dynatrace-archive")
+
+			_, _, err := dt.ResolveBinding(binds, "BP")
+			Expect(err).To(MatchError("no binding named dynatrace-archive found for $BP_DYNATRACE_BINDING_NAME, candidates are: dynatrace-config, team-credentials"))
+		})
+	})
+}
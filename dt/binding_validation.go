@@ -0,0 +1,114 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+)
+
+// BindingKeys are the secret keys of a Dynatrace binding consumed by the buildpack.
+var BindingKeys = []string{
+	"api-token", "apitoken", "api-url", "apiurl", "environment-id", "managed-domain", "saas-domain", "network-zone",
+	CACertificateKey, ClientCertificateKey, ClientKeyKey,
+}
+
+// ValidateBinding checks the secret of a Dynatrace binding. It reports a missing API token, a missing API URL or
+// environment id, an API URL that is not a valid https URL and keys that look like misspelled BindingKeys,
+// suggesting the intended key.
+func ValidateBinding(binding libcnb.Binding) error {
+	var problems []string
+
+	var keys []string
+	for k := range binding.Secret {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if s, ok := suggestBindingKey(k); ok {
+			problems = append(problems, fmt.Sprintf("unknown key %s, did you mean %s?", k, s))
+		}
+	}
+
+	if strings.TrimSpace(APIToken(binding)) == "" {
+		problems = append(problems, "api-token must be set")
+	}
+
+	_, hasURL := binding.Secret["api-url"]
+	_, hasAltURL := binding.Secret["apiurl"]
+	_, hasID := binding.Secret["environment-id"]
+	if !hasURL && !hasAltURL && !hasID {
+		problems = append(problems, "api-url or environment-id must be set")
+	} else if uri, err := BaseURI(binding); err != nil {
+		problems = append(problems, err.Error())
+	} else if u, err := url.Parse(uri); err != nil || u.Scheme != "https" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("Dynatrace API URL %s must be a valid https URL", uri))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid Dynatrace binding %s: %s", binding.Name, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// suggestBindingKey returns the binding key that key is likely a misspelling of, differing only in case and separators
+// or by up to two edits.
+func suggestBindingKey(key string) (string, bool) {
+	for _, k := range BindingKeys {
+		if key == k {
+			return "", false
+		}
+	}
+
+	normalized := strings.NewReplacer("_", "-", " ", "-").Replace(strings.ToLower(key))
+	for _, k := range BindingKeys {
+		if normalized == k || editDistance(normalized, k) <= 2 {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testValidateBinding(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	binding := func(secret map[string]string) libcnb.Binding {
+		return libcnb.Binding{Name: "test-binding", Type: "Dynatrace", Secret: secret}
+	}
+
+	it("accepts valid binding", func() {
+		Expect(dt.ValidateBinding(binding(map[string]string{
+			"api-token": "test-api-token",
+			"api-url":   "https://test.example.com/e/test-id/api",
+			"test-key":  "test-value",
+		}))).To(Succeed())
+	})
+
+	it("accepts alternate spellings", func() {
+		Expect(dt.ValidateBinding(binding(map[string]string{
+			"apitoken": "test-api-token",
+			"apiurl":   "https://test.example.com/e/test-id/api",
+		}))).To(Succeed())
+	})
+
+	it("accepts environment id", func() {
+		Expect(dt.ValidateBinding(binding(map[string]string{
+			"api-token":      "test-api-token",
+			"environment-id": "test-id",
+		}))).To(Succeed())
+	})
+
+	it("rejects http to loopback", func() {
+		Expect(dt.ValidateBinding(binding(map[string]string{
+			"api-token": "test-api-token",
+			"api-url":   "http://127.0.0.1:9999/e/test-id/api",
+		}))).To(MatchError(ContainSubstring("Dynatrace API URL http://127.0.0.1:9999/e/test-id/api must be a valid https URL")))
+	})
+
+	it("reports missing keys", func() {
+		Expect(dt.ValidateBinding(binding(map[string]string{}))).
+			To(MatchError("invalid Dynatrace binding test-binding: api-token must be set; api-url or environment-id must be set"))
+	})
+
+	it("suggests fixes for near-miss keys", func() {
+		Expect(dt.ValidateBinding(binding(map[string]string{
+			"API_TOKEN":     "test-api-token",
+			"enviroment-id": "test-id",
+		}))).To(MatchError("invalid Dynatrace binding test-binding: " +
+			"unknown key API_TOKEN, did you mean api-token?; " +
+			"unknown key enviroment-id, did you mean environment-id?; " +
+			"api-token must be set; " +
+			"api-url or environment-id must be set"))
+	})
+
+	it("rejects api-url that is not https", func() {
+		Expect(dt.ValidateBinding(binding(map[string]string{
+			"api-token": "test-api-token",
+			"api-url":   "http://test.example.com/api",
+		}))).To(MatchError(ContainSubstring("Dynatrace API URL http://test.example.com/api must be a valid https URL")))
+	})
+
+	it("rejects invalid api-url", func() {
+		Expect(dt.ValidateBinding(binding(map[string]string{
+			"api-token": "test-api-token",
+			"api-url":   "test.example.com",
+		}))).To(MatchError(ContainSubstring("scheme must be http or https")))
+	})
+}
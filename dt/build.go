@@ -17,16 +17,18 @@
 package dt
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"runtime"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/buildpacks/libcnb"
+	"github.com/heroku/color"
 	"github.com/paketo-buildpacks/libpak"
 	"github.com/paketo-buildpacks/libpak/bard"
-	"github.com/paketo-buildpacks/libpak/bindings"
 )
 
 type Build struct {
@@ -37,38 +39,143 @@ func (b Build) Build(context libcnb.BuildContext) (libcnb.BuildResult, error) {
 	b.Logger.Title(context.Buildpack)
 	result := libcnb.NewBuildResult()
 
+	cr, err := libpak.NewConfigurationResolver(context.Buildpack, &b.Logger)
+	if err != nil {
+		return libcnb.BuildResult{}, fmt.Errorf("unable to create configuration resolver\n%w", err)
+	}
+
 	pr := libpak.PlanEntryResolver{Plan: context.Plan}
 
+	arch, err := ResolveArchitecture()
+	if err != nil {
+		policy, _ := cr.Resolve("BP_DYNATRACE_ARCH_POLICY")
+		switch policy {
+		case "", ArchPolicyFail:
+			return libcnb.BuildResult{}, err
+		case ArchPolicySkip:
+			b.Logger.Bodyf("%s %s, skipping Dynatrace OneAgent", color.New(color.FgYellow, color.Bold).Sprint("Warning:"), err)
+			return result, nil
+		default:
+			return libcnb.BuildResult{}, fmt.Errorf("unsupported $BP_DYNATRACE_ARCH_POLICY %s, must be one of %s or %s",
+				policy, ArchPolicyFail, ArchPolicySkip)
+		}
+	}
+
 	dc, err := libpak.NewDependencyCache(context)
 	if err != nil {
 		return libcnb.BuildResult{}, fmt.Errorf("unable to create dependency cache\n%w", err)
 	}
 	dc.Logger = b.Logger
 
-	s, _, err := bindings.ResolveOne(context.Platform.Bindings, IsDynatraceBinding)
+	archive, offline, err := ResolveAgentArchive(context.Platform.Bindings, cr)
+	if err != nil {
+		return libcnb.BuildResult{}, fmt.Errorf("unable to resolve Dynatrace OneAgent archive\n%w", err)
+	}
+
+	var (
+		s      libcnb.Binding
+		client Client
+	)
+	if offline {
+		// the binding only matters at launch time, so a pre-staged agent needs no tenant credentials. The CA
+		// certificates of a binding are still passed to OneAgent.
+		if b, ok, err := ResolveBinding(context.Platform.Bindings, "BP"); err == nil && ok {
+			s = b
+		}
+	} else {
+		if s, _, err = ResolveBinding(context.Platform.Bindings, "BP"); err != nil {
+			return libcnb.BuildResult{}, fmt.Errorf("unable to resolve binding Dynatrace\n%w", err)
+		}
+
+		if err := ValidateBinding(s); err != nil {
+			return libcnb.BuildResult{}, err
+		}
+
+		tc, err := TLSConfig(s)
+		if err != nil {
+			return libcnb.BuildResult{}, fmt.Errorf("unable to configure TLS for Dynatrace\n%w", err)
+		}
+
+		if client, err = NewClientFromEnvironment("BP", 30, 120, tc); err != nil {
+			return libcnb.BuildResult{}, fmt.Errorf("unable to create Dynatrace client\n%w", err)
+		}
+		client.Logger = b.Logger
+	}
+
+	includes, err := Technologies(pr)
 	if err != nil {
-		return libcnb.BuildResult{}, fmt.Errorf("unable to resolve binding Dynatrace\n%w", err)
+		return libcnb.BuildResult{}, err
 	}
 
-	v, err := b.AgentVersion(s, context.Buildpack.Info)
+	explicitIncludes, explicit := cr.Resolve("BP_DYNATRACE_TECHNOLOGIES")
+	if explicit {
+		includes, err = ParseTechnologies(explicitIncludes)
+		if err != nil {
+			return libcnb.BuildResult{}, fmt.Errorf("unable to parse $BP_DYNATRACE_TECHNOLOGIES\n%w", err)
+		}
+		b.Logger.Bodyf("Including Dynatrace OneAgent technologies %s", strings.Join(includes, ", "))
+	}
+
+	requestedFlavor, _ := cr.Resolve("BP_DYNATRACE_FLAVOR")
+	libc := LibcForTarget(context.StackID)
+	flavor, warning, err := ResolveFlavor(requestedFlavor, libc)
 	if err != nil {
-		return libcnb.BuildResult{}, fmt.Errorf("unable to determine agent version\n%w", err)
+		return libcnb.BuildResult{}, fmt.Errorf("unable to resolve Dynatrace OneAgent flavor\n%w", err)
+	}
+	if warning != "" {
+		b.Logger.Bodyf("%s %s", color.New(color.FgYellow, color.Bold).Sprint("Warning:"), warning)
 	}
 
-	uri := fmt.Sprintf("%s/v1/deployment/installer/agent/unix/paas/latest?bitness=64&skipMetadata=true&arch=%s", BaseURI(s), archForDynatrace())
+	var (
+		v      string
+		uri    string
+		sha256 string
+	)
 
-	// not presently a specific python module, but we include "all" then it should work with Python
-	if _, ok, err := pr.Resolve("dynatrace-python"); err != nil {
-		return libcnb.BuildResult{}, fmt.Errorf("unable to resolve dynatrace-python plan entry\n%w", err)
-	} else if ok {
-		uri = fmt.Sprintf("%s&include=all", uri)
+	if offline {
+		b.Logger.Bodyf("Using pre-staged Dynatrace OneAgent %s from %s", archive.Version, archive.Path)
+		if missing := archive.Missing(includes); len(missing) > 0 {
+			b.Logger.Bodyf("%s pre-staged Dynatrace OneAgent does not include %s",
+				color.New(color.FgYellow, color.Bold).Sprint("Warning:"), strings.Join(missing, ", "))
+		}
+
+		v = archive.Version
+		uri = fmt.Sprintf("file://%s", archive.Path)
+		sha256 = archive.SHA256
 	} else {
-		for _, t := range []string{"apache", "dotnet", "go", "java", "nginx", "nodejs", "php"} {
-			if _, ok, err := pr.Resolve(fmt.Sprintf("dynatrace-%s", t)); err != nil {
-				return libcnb.BuildResult{}, fmt.Errorf("unable to resolve dynatrace-%s plan entry\n%w", t, err)
-			} else if ok {
-				uri = fmt.Sprintf("%s&include=%s", uri, t)
-			}
+		if err := b.Preflight(client, s, context.Buildpack.Info); err != nil {
+			return libcnb.BuildResult{}, err
+		}
+
+		requested, _ := cr.Resolve("BP_DYNATRACE_AGENT_VERSION")
+
+		v, err = b.AgentVersion(client, s, context.Buildpack.Info, requested, arch, flavor)
+		if err != nil {
+			return libcnb.BuildResult{}, fmt.Errorf("unable to determine agent version\n%w", err)
+		}
+
+		path := "latest"
+		if !isLatest(requested) {
+			path = fmt.Sprintf("version/%s", v)
+		}
+
+		base, err := BaseURI(s)
+		if err != nil {
+			return libcnb.BuildResult{}, fmt.Errorf("unable to determine Dynatrace API URL\n%w", err)
+		}
+
+		uri = fmt.Sprintf("%s/v1/deployment/installer/agent/unix/paas/%s?bitness=64&skipMetadata=true&arch=%s%s", base, path, arch.Dynatrace, flavorQuery(flavor))
+		for _, i := range includes {
+			uri = fmt.Sprintf("%s&include=%s", uri, i)
+		}
+
+		sha256, _ = cr.Resolve("BP_DYNATRACE_AGENT_SHA256")
+		if sha256 == "" && exactAgentVersion.MatchString(requested) {
+			return libcnb.BuildResult{}, fmt.Errorf("$BP_DYNATRACE_AGENT_SHA256 must be set when $BP_DYNATRACE_AGENT_VERSION pins version %s", requested)
+		}
+		if sha256 != "" && isLatest(requested) {
+			b.Logger.Bodyf("%s $BP_DYNATRACE_AGENT_SHA256 is set without pinning $BP_DYNATRACE_AGENT_VERSION, verification will fail once a new agent is released",
+				color.New(color.FgYellow, color.Bold).Sprint("Warning:"))
 		}
 	}
 
@@ -77,14 +184,58 @@ func (b Build) Build(context libcnb.BuildContext) (libcnb.BuildResult, error) {
 		Name:    "Dynatrace OneAgent",
 		Version: v,
 		URI:     uri,
-		SHA256:  "",
+		SHA256:  strings.ToLower(sha256),
 		Stacks:  []string{context.StackID},
-		PURL:    fmt.Sprintf("pkg:generic/dynatrace-one-agent@%s?arch=%s", v, archFromSystem()),
+		PURL:    fmt.Sprintf("pkg:generic/dynatrace-one-agent@%s?arch=%s", v, arch.Name),
 		CPEs:    []string{fmt.Sprintf("cpe:2.3:a:dynatrace:one-agent:%s:*:*:*:*:*:*:*", v)},
 	}
 
 	a, be := NewAgent(dep, dc, APIToken(s), context.Buildpack.Info)
 	a.Logger = b.Logger
+	a.Architecture = arch.Name
+	a.Musl = libc == LibcMusl || (libc == LibcUnknown && flavor == FlavorMusl)
+	if explicit {
+		a.ExpectMetadata("technologies", includes)
+	}
+	if ca, ok := s.Secret[CACertificateKey]; ok {
+		a.CACertificates = ca
+		a.ExpectMetadata("ca-certificates", CertificatesDigest(ca))
+	}
+	if a.Injection, err = b.Injection(cr, pr); err != nil {
+		return libcnb.BuildResult{}, err
+	}
+	if a.Injection != InjectionPreload {
+		a.ExpectMetadata("injection", a.Injection)
+	}
+	if t, ok := cr.Resolve("BP_DYNATRACE_PROCESS_TYPES"); ok {
+		if a.ProcessTypes = ProcessTypes(t); len(a.ProcessTypes) > 0 {
+			a.ExpectMetadata("process-types", a.ProcessTypes)
+		}
+	}
+	if cr.ResolveBool("BP_DYNATRACE_PRUNE") {
+		a.Prune = true
+		a.Technologies = includes
+		a.ExpectMetadata("prune", true)
+	}
+	if cr.ResolveBool("BP_DYNATRACE_EMBED_CONNECTION_INFO") {
+		if offline {
+			return libcnb.BuildResult{}, fmt.Errorf("$BP_DYNATRACE_EMBED_CONNECTION_INFO requires access to the tenant and cannot be used with a pre-staged OneAgent archive")
+		}
+
+		c, err := GetConnectionInfo(client, s, fmt.Sprintf("%s/%s", context.Buildpack.Info.ID, context.Buildpack.Info.Version), s.Secret["network-zone"])
+		if err != nil {
+			return libcnb.BuildResult{}, fmt.Errorf("unable to get Dynatrace connection info\n%w", err)
+		}
+
+		b.Logger.Bodyf("Embedding connection info for tenant %s", c.TenantUUID)
+		a.ConnectionInfo = &c
+		a.ExpectMetadata("connection-info", c.Digest())
+	}
+	if !offline {
+		// the agent is downloaded with the Dynatrace client as libpak can neither use a custom CA or client certificate
+		// nor return the error message of the tenant
+		a.Client = &client
+	}
 	result.Layers = append(result.Layers, a)
 	result.BOM.Entries = append(result.BOM.Entries, be)
 
@@ -93,59 +244,260 @@ func (b Build) Build(context libcnb.BuildContext) (libcnb.BuildResult, error) {
 	result.Layers = append(result.Layers, h)
 	result.BOM.Entries = append(result.BOM.Entries, be)
 
+	d := NewDoctor(context.Buildpack)
+	d.Logger = b.Logger
+	result.Layers = append(result.Layers, d)
+
 	return result, nil
 }
 
-func (Build) AgentVersion(binding libcnb.Binding, info libcnb.BuildpackInfo) (string, error) {
-	uri := fmt.Sprintf("%s/v1/deployment/installer/agent/unix/paas/latest/metainfo", BaseURI(binding))
+// Technologies returns the OneAgent code modules to include for the resolved dynatrace-* plan entries.
+func Technologies(pr libpak.PlanEntryResolver) ([]string, error) {
+	// not presently a specific python module, but we include "all" then it should work with Python
+	if _, ok, err := pr.Resolve("dynatrace-python"); err != nil {
+		return nil, fmt.Errorf("unable to resolve dynatrace-python plan entry\n%w", err)
+	} else if ok {
+		return []string{"all"}, nil
+	}
+
+	var includes []string
+	for _, t := range []string{"apache", "dotnet", "go", "java", "nginx", "nodejs", "php"} {
+		if _, ok, err := pr.Resolve(fmt.Sprintf("dynatrace-%s", t)); err != nil {
+			return nil, fmt.Errorf("unable to resolve dynatrace-%s plan entry\n%w", t, err)
+		} else if ok {
+			includes = append(includes, t)
+		}
+	}
+
+	return includes, nil
+}
+
+// Injection resolves how OneAgent is loaded into the application from $BP_DYNATRACE_INJECTION. The agentpath mode only
+// applies to JVM applications and the node-options mode to Node.js applications, both fall back to LD_PRELOAD
+// otherwise.
+func (b Build) Injection(cr libpak.ConfigurationResolver, pr libpak.PlanEntryResolver) (string, error) {
+	injection, _ := cr.Resolve("BP_DYNATRACE_INJECTION")
+
+	switch injection {
+	case "", InjectionPreload:
+		return InjectionPreload, nil
+	case InjectionAgentPath:
+		return b.injectionFor(pr, injection, "dynatrace-java", "a JVM application")
+	case InjectionNodeOptions:
+		return b.injectionFor(pr, injection, "dynatrace-nodejs", "a Node.js application")
+	default:
+		return "", fmt.Errorf("unsupported $BP_DYNATRACE_INJECTION %s, must be one of %s, %s or %s",
+			injection, InjectionPreload, InjectionAgentPath, InjectionNodeOptions)
+	}
+}
+
+func (b Build) injectionFor(pr libpak.PlanEntryResolver, injection string, entry string, description string) (string, error) {
+	if _, ok, err := pr.Resolve(entry); err != nil {
+		return "", fmt.Errorf("unable to resolve %s plan entry\n%w", entry, err)
+	} else if !ok {
+		b.Logger.Bodyf("%s $BP_DYNATRACE_INJECTION=%s requires %s, using %s",
+			color.New(color.FgYellow, color.Bold).Sprint("Warning:"), injection, description, InjectionPreload)
+		return InjectionPreload, nil
+	}
+
+	return injection, nil
+}
+
+// ProcessTypes parses a comma separated list of process types, such as web,api. The result is sorted and free of
+// duplicates.
+func ProcessTypes(s string) []string {
+	seen := make(map[string]bool)
+	var types []string
+
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" && !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+
+	sort.Strings(types)
+	return types
+}
+
+// KnownTechnologies are the OneAgent code modules that can be included in a download.
+var KnownTechnologies = []string{"all", "apache", "dotnet", "go", "java", "nginx", "nodejs", "php", "sdk"}
+
+// ParseTechnologies parses a comma separated list of OneAgent code modules, such as java,nodejs,sdk. The result is
+// sorted and free of duplicates so that equivalent lists produce the same download.
+func ParseTechnologies(s string) ([]string, error) {
+	seen := make(map[string]bool)
+	var includes []string
+
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+
+		known := false
+		for _, k := range KnownTechnologies {
+			if t == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unsupported technology %s, must be one of %s", t, strings.Join(KnownTechnologies, ", "))
+		}
+
+		seen[t] = true
+		includes = append(includes, t)
+	}
+
+	if len(includes) == 0 {
+		return nil, fmt.Errorf("no technologies listed")
+	}
+
+	sort.Strings(includes)
+	return includes, nil
+}
+
+// Preflight checks that the API token has the RequiredScopes before anything is downloaded. Tenants that do not
+// support the token lookup only produce a warning, whereas a rejected token or missing scopes fail the build.
+func (b Build) Preflight(client Client, binding libcnb.Binding, info libcnb.BuildpackInfo) error {
+	err := CheckTokenScopes(client, binding, fmt.Sprintf("%s/%s", info.ID, info.Version), RequiredScopes)
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode != http.StatusUnauthorized {
+		b.Logger.Bodyf("%s unable to verify Dynatrace API token scopes: %s",
+			color.New(color.FgYellow, color.Bold).Sprint("Warning:"), apiErr)
+		return nil
+	}
+
+	return fmt.Errorf("unable to verify Dynatrace API token\n%w", err)
+}
+
+var exactAgentVersion = regexp.MustCompile(`^\d+\.\d+\.\d+\.\d{8}-\d{6}$`)
+
+// AgentVersion resolves the OneAgent version to install. An empty or "latest" request returns the latest version
+// published by the tenant, anything else is matched against the versions available for the current architecture,
+// either exactly or as a semver constraint such as 1.29.*.
+func (b Build) AgentVersion(client Client, binding libcnb.Binding, info libcnb.BuildpackInfo, requested string, arch Architecture, flavor string) (string, error) {
+	if isLatest(requested) {
+		return b.latestAgentVersion(client, binding, info, flavor)
+	}
 
-	req, err := http.NewRequest("GET", uri, nil)
+	available, err := b.availableAgentVersions(client, binding, info, arch, flavor)
 	if err != nil {
-		return "", fmt.Errorf("unable to create new GET request for %s\n%w", uri, err)
+		return "", err
+	}
+
+	for _, a := range available {
+		if a == requested {
+			return a, nil
+		}
+	}
+
+	unavailable := fmt.Errorf("no Dynatrace OneAgent version matching %s is available for arch %s and flavor %s\navailable versions: %s",
+		requested, arch.Dynatrace, flavor, strings.Join(available, ", "))
+
+	// exact versions such as 1.283.123.20240101-123456 are not valid constraints
+	if exactAgentVersion.MatchString(requested) {
+		return "", unavailable
+	}
+
+	c, err := semver.NewConstraint(requested)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse version constraint %s\n%w", requested, err)
+	}
+
+	var candidates []string
+	versions := make(map[string]*semver.Version)
+	for _, a := range available {
+		sv, err := agentSemver(a)
+		if err != nil {
+			continue
+		}
+
+		if c.Check(sv) {
+			candidates = append(candidates, a)
+			versions[a] = sv
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", unavailable
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Api-Token %s", APIToken(binding)))
-	req.Header.Set("User-Agent", fmt.Sprintf("%s/%s", info.ID, info.Version))
 
-	client := http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
-	resp, err := client.Do(req)
+	sort.Slice(candidates, func(i, j int) bool {
+		if versions[candidates[i]].Equal(versions[candidates[j]]) {
+			return candidates[i] < candidates[j]
+		}
+		return versions[candidates[i]].LessThan(versions[candidates[j]])
+	})
+
+	return candidates[len(candidates)-1], nil
+}
+
+func (Build) latestAgentVersion(client Client, binding libcnb.Binding, info libcnb.BuildpackInfo, flavor string) (string, error) {
+	base, err := BaseURI(binding)
 	if err != nil {
-		return "", fmt.Errorf("unable to request %s\n%w", uri, err)
+		return "", fmt.Errorf("unable to determine Dynatrace API URL\n%w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return "", fmt.Errorf("could not download %s: %d", uri, resp.StatusCode)
+	uri := fmt.Sprintf("%s/v1/deployment/installer/agent/unix/paas/latest/metainfo", base)
+	if q := flavorQuery(flavor); q != "" {
+		uri = fmt.Sprintf("%s?%s", uri, strings.TrimPrefix(q, "&"))
 	}
 
 	raw := struct {
 		LatestAgentVersion string `json:"latestAgentVersion"`
 	}{}
 
-	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
-		return "", fmt.Errorf("unable to decode payload\n%w", err)
+	if err := client.GetJSON(uri, APIToken(binding), fmt.Sprintf("%s/%s", info.ID, info.Version), &raw); err != nil {
+		return "", err
 	}
 
 	return raw.LatestAgentVersion, nil
 }
 
-func archFromSystem() string {
-	archFromEnv, ok := os.LookupEnv("BP_ARCH")
-	if !ok {
-		archFromEnv = runtime.GOARCH
+func (Build) availableAgentVersions(client Client, binding libcnb.Binding, info libcnb.BuildpackInfo, arch Architecture, flavor string) ([]string, error) {
+	base, err := BaseURI(binding)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine Dynatrace API URL\n%w", err)
 	}
 
-	return archFromEnv
+	uri := fmt.Sprintf("%s/v1/deployment/installer/agent/versions/unix/paas?arch=%s%s", base, arch.Dynatrace, flavorQuery(flavor))
+
+	raw := struct {
+		AvailableVersions []string `json:"availableVersions"`
+	}{}
+
+	if err := client.GetJSON(uri, APIToken(binding), fmt.Sprintf("%s/%s", info.ID, info.Version), &raw); err != nil {
+		return nil, err
+	}
+
+	return raw.AvailableVersions, nil
+}
+
+// flavorQuery returns the flavor query parameter, omitted for the default flavor.
+func flavorQuery(flavor string) string {
+	if flavor == "" || flavor == FlavorDefault {
+		return ""
+	}
+	return fmt.Sprintf("&flavor=%s", flavor)
 }
 
-func archForDynatrace() string {
-	archFromEnv := archFromSystem()
+func isLatest(version string) bool {
+	return version == "" || strings.EqualFold(version, "latest")
+}
 
-	// https://docs.dynatrace.com/docs/dynatrace-api/environment-api/deployment/oneagent/download-oneagent-version?secureweb=Teams
-	if (archFromEnv == "amd64") || (archFromEnv == "x86_64") {
-		return "x86"
-	} else if (archFromEnv == "aarch64") || (archFromEnv == "arm64") {
-		return "arm"
-	} else {
-		return "x86"
+// agentSemver converts a OneAgent version such as 1.283.123.20240101-123456 to a semver using its first three segments.
+func agentSemver(version string) (*semver.Version, error) {
+	parts := strings.SplitN(version, ".", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
 	}
+
+	return semver.NewVersion(strings.Join(parts, "."))
 }
@@ -17,8 +17,12 @@
 package dt_test
 
 import (
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/buildpacks/libcnb"
@@ -37,7 +41,7 @@ func getExpectedDependency(serverUrl string) libpak.BuildpackDependency {
 		ID:      "dynatrace-oneagent",
 		Name:    "Dynatrace OneAgent",
 		Version: "test-version",
-		URI:     fmt.Sprintf("%s/v1/deployment/installer/agent/unix/paas/latest?bitness=64&skipMetadata=true&arch=x86&include=java&include=php", serverUrl),
+		URI:     fmt.Sprintf("%s/api/v1/deployment/installer/agent/unix/paas/latest?bitness=64&skipMetadata=true&arch=x86&include=java&include=php", serverUrl),
 		Stacks:  []string{stackId},
 		PURL:    "pkg:generic/dynatrace-one-agent@test-version?arch=amd64",
 		CPEs:    []string{"cpe:2.3:a:dynatrace:one-agent:test-version:*:*:*:*:*:*:*"},
@@ -49,7 +53,7 @@ func getExpectedAllDependency(serverUrl string) libpak.BuildpackDependency {
 		ID:      "dynatrace-oneagent",
 		Name:    "Dynatrace OneAgent",
 		Version: "test-version",
-		URI:     fmt.Sprintf("%s/v1/deployment/installer/agent/unix/paas/latest?bitness=64&skipMetadata=true&arch=arm&include=all", serverUrl),
+		URI:     fmt.Sprintf("%s/api/v1/deployment/installer/agent/unix/paas/latest?bitness=64&skipMetadata=true&arch=arm&include=all", serverUrl),
 		Stacks:  []string{stackId},
 		PURL:    "pkg:generic/dynatrace-one-agent@test-version?arch=arm64",
 		CPEs:    []string{"cpe:2.3:a:dynatrace:one-agent:test-version:*:*:*:*:*:*:*"},
@@ -68,24 +72,29 @@ func verifyBOM(bom *libcnb.BOM) {
 }
 
 func verifyLayers(layers []libcnb.LayerContributor, serverUrl string, expectation func(string) libpak.BuildpackDependency) {
-	ExpectWithOffset(1, layers).To(HaveLen(2))
+	ExpectWithOffset(1, layers).To(HaveLen(3))
 	ExpectWithOffset(1, layers[0].Name()).To(Equal("dynatrace-oneagent"))
 	ExpectWithOffset(1, layers[0].(dt.Agent).LayerContributor.Dependency).To(Equal(expectation(serverUrl)))
 	ExpectWithOffset(1, layers[1].Name()).To(Equal("helper"))
 	ExpectWithOffset(1, layers[1].(libpak.HelperLayerContributor).Names).To(Equal([]string{"properties"}))
+	ExpectWithOffset(1, layers[2].Name()).To(Equal("doctor"))
 }
 
 func testBuild(t *testing.T, context spec.G, it spec.S) {
 	var (
 		Expect = NewWithT(t).Expect
 
-		ctx    libcnb.BuildContext
-		server *ghttp.Server
+		ca          string
+		ctx         libcnb.BuildContext
+		server      *ghttp.Server
+		tokenStatus int
+		token       map[string]interface{}
 	)
 
 	it.Before(func() {
 		RegisterTestingT(t)
-		server = ghttp.NewServer()
+		server = ghttp.NewTLSServer()
+		ca = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.HTTPTestServer.Certificate().Raw}))
 
 		t.Setenv("BP_ARCH", "amd64")
 
@@ -101,12 +110,17 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 				Secret: map[string]string{
 					"api-token": "test-api-token",
 					"api-url":   server.URL(),
+					"ca.crt":    ca,
 				},
 			},
 		}
 
+		tokenStatus = http.StatusOK
+		token = map[string]interface{}{"name": "test-token", "enabled": true, "scopes": []string{"InstallerDownload"}}
+		server.RouteToHandler("POST", "/api/v2/apiTokens/lookup", ghttp.RespondWithJSONEncodedPtr(&tokenStatus, &token))
+
 		server.AppendHandlers(ghttp.CombineHandlers(
-			ghttp.VerifyRequest("GET", "/v1/deployment/installer/agent/unix/paas/latest/metainfo"),
+			ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/unix/paas/latest/metainfo"),
 			ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
 			ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
 			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"latestAgentVersion": "test-version"}),
@@ -129,6 +143,15 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		verifyBOM(result.BOM)
 	})
 
+	it("passes ca.crt to the agent", func() {
+		result, err := dt.Build{}.Build(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		a := result.Layers[0].(dt.Agent)
+		Expect(a.CACertificates).To(Equal(ca))
+		Expect(a.LayerContributor.ExpectedMetadata).To(HaveKeyWithValue("ca-certificates", dt.CertificatesDigest(ca)))
+	})
+
 	it("also takes named binding into account", func() {
 		ctx.Platform.Bindings = libcnb.Bindings{
 			{
@@ -137,12 +160,13 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 				Secret: map[string]string{
 					"api-token": "custom-api-token",
 					"api-url":   server.URL(),
+					"ca.crt":    ca,
 				},
 			},
 		}
 
 		server.SetHandler(0, ghttp.CombineHandlers(
-			ghttp.VerifyRequest("GET", "/v1/deployment/installer/agent/unix/paas/latest/metainfo"),
+			ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/unix/paas/latest/metainfo"),
 			ghttp.VerifyHeaderKV("Authorization", "Api-Token custom-api-token"),
 			ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
 			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"latestAgentVersion": "test-version"}),
@@ -163,12 +187,13 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 				Secret: map[string]string{
 					"apitoken": "custom-apitoken",
 					"apiurl":   server.URL(),
+					"ca.crt":   ca,
 				},
 			},
 		}
 
 		server.SetHandler(0, ghttp.CombineHandlers(
-			ghttp.VerifyRequest("GET", "/v1/deployment/installer/agent/unix/paas/latest/metainfo"),
+			ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/unix/paas/latest/metainfo"),
 			ghttp.VerifyHeaderKV("Authorization", "Api-Token custom-apitoken"),
 			ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
 			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"latestAgentVersion": "test-version"}),
@@ -181,6 +206,445 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		verifyBOM(result.BOM)
 	})
 
+	it("uses trusted SHA256", func() {
+		t.Setenv("BP_DYNATRACE_AGENT_SHA256", "1DA90986465057B9B455363124A52FAC78F025E5295C989807E090018CC37DC1")
+
+		result, err := dt.Build{}.Build(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result.Layers[0].(dt.Agent).LayerContributor.Dependency.SHA256).
+			To(Equal("1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1"))
+		Expect(result.BOM.Entries[0].Metadata["sha256"]).
+			To(Equal("1da90986465057b9b455363124a52fac78f025e5295c989807e090018cc37dc1"))
+	})
+
+	context("$BP_DYNATRACE_EMBED_CONNECTION_INFO", func() {
+		it.Before(func() {
+			t.Setenv("BP_DYNATRACE_EMBED_CONNECTION_INFO", "true")
+		})
+
+		it("embeds connection info", func() {
+			ctx.Platform.Bindings[0].Secret["network-zone"] = "test-zone"
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/connectioninfo", "networkZone=test-zone"),
+				ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+					"tenantUUID":             "test-tenant-uuid",
+					"tenantToken":            "test-tenant-token",
+					"communicationEndpoints": []string{"test-communication-endpoint-1", "test-communication-endpoint-2"},
+				}),
+			))
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			a := result.Layers[0].(dt.Agent)
+			Expect(a.ConnectionInfo).To(Equal(&dt.ConnectionInfo{
+				TenantUUID:             "test-tenant-uuid",
+				TenantToken:            "test-tenant-token",
+				CommunicationEndpoints: []string{"test-communication-endpoint-1", "test-communication-endpoint-2"},
+				NetworkZone:            "test-zone",
+			}))
+			Expect(a.LayerContributor.ExpectedMetadata).To(HaveKeyWithValue("connection-info", a.ConnectionInfo.Digest()))
+			Expect(a.LayerContributor.ExpectedMetadata).To(HaveKeyWithValue("dependency", getExpectedDependency(server.URL())))
+		})
+
+		it("fails with pre-staged archive", func() {
+			path := t.TempDir()
+			Expect(os.WriteFile(filepath.Join(path, "oneagent.zip"), []byte{}, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(path, "metadata.toml"), []byte(`version = "test-version"`), 0644)).To(Succeed())
+			t.Setenv("BP_DYNATRACE_AGENT_ARCHIVE", path)
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("cannot be used with a pre-staged OneAgent archive")))
+		})
+	})
+
+	context("flavor", func() {
+		it("uses musl for musl based run images", func() {
+			t.Setenv("CNB_TARGET_DISTRO_NAME", "alpine")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(server.ReceivedRequests()[1].URL.RawQuery).To(Equal("flavor=musl"))
+			Expect(result.Layers[0].(dt.Agent).LayerContributor.Dependency.URI).To(Equal(fmt.Sprintf("%s/api/v1/deployment/installer/agent/unix/paas/latest?bitness=64&skipMetadata=true&arch=x86&flavor=musl&include=java&include=php", server.URL())))
+			Expect(result.Layers[0].(dt.Agent).Musl).To(BeTrue())
+		})
+
+		it("uses $BP_DYNATRACE_FLAVOR", func() {
+			t.Setenv("BP_DYNATRACE_FLAVOR", "multidistro")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers[0].(dt.Agent).LayerContributor.Dependency.URI).To(ContainSubstring("&flavor=multidistro&"))
+			Expect(result.Layers[0].(dt.Agent).Musl).To(BeFalse())
+		})
+
+		it("fails on unsupported $BP_DYNATRACE_FLAVOR", func() {
+			t.Setenv("BP_DYNATRACE_FLAVOR", "test-flavor")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("unsupported flavor test-flavor")))
+		})
+	})
+
+	it("restricts agent to $BP_DYNATRACE_PROCESS_TYPES", func() {
+		t.Setenv("BP_DYNATRACE_PROCESS_TYPES", "web, api,web")
+
+		result, err := dt.Build{}.Build(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		a := result.Layers[0].(dt.Agent)
+		Expect(a.ProcessTypes).To(Equal([]string{"api", "web"}))
+		Expect(a.LayerContributor.ExpectedMetadata).To(HaveKeyWithValue("process-types", []string{"api", "web"}))
+	})
+
+	context("$BP_DYNATRACE_INJECTION", func() {
+		it("uses LD_PRELOAD by default", func() {
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers[0].(dt.Agent).Injection).To(Equal(dt.InjectionPreload))
+		})
+
+		it("uses agentpath for JVM applications", func() {
+			t.Setenv("BP_DYNATRACE_INJECTION", "agentpath")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			a := result.Layers[0].(dt.Agent)
+			Expect(a.Injection).To(Equal(dt.InjectionAgentPath))
+			Expect(a.LayerContributor.ExpectedMetadata).To(HaveKeyWithValue("injection", dt.InjectionAgentPath))
+		})
+
+		it("falls back to LD_PRELOAD for other applications", func() {
+			t.Setenv("BP_DYNATRACE_INJECTION", "agentpath")
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{{Name: "dynatrace-php"}}
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers[0].(dt.Agent).Injection).To(Equal(dt.InjectionPreload))
+		})
+
+		it("uses node-options for Node.js applications", func() {
+			t.Setenv("BP_DYNATRACE_INJECTION", "node-options")
+			ctx.Plan.Entries = []libcnb.BuildpackPlanEntry{{Name: "dynatrace-nodejs"}}
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			a := result.Layers[0].(dt.Agent)
+			Expect(a.Injection).To(Equal(dt.InjectionNodeOptions))
+			Expect(a.LayerContributor.ExpectedMetadata).To(HaveKeyWithValue("injection", dt.InjectionNodeOptions))
+		})
+
+		it("falls back to LD_PRELOAD for node-options without Node.js", func() {
+			t.Setenv("BP_DYNATRACE_INJECTION", "node-options")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers[0].(dt.Agent).Injection).To(Equal(dt.InjectionPreload))
+		})
+
+		it("fails on unsupported injection", func() {
+			t.Setenv("BP_DYNATRACE_INJECTION", "test-injection")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("unsupported $BP_DYNATRACE_INJECTION test-injection")))
+		})
+	})
+
+	context("arch", func() {
+		it("uses ppcle for ppc64le", func() {
+			t.Setenv("BP_ARCH", "ppc64le")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := result.Layers[0].(dt.Agent).LayerContributor.Dependency
+			Expect(dep.URI).To(ContainSubstring("&arch=ppcle&"))
+			Expect(dep.PURL).To(Equal("pkg:generic/dynatrace-one-agent@test-version?arch=ppc64le"))
+		})
+
+		it("normalizes PURL arch", func() {
+			t.Setenv("BP_ARCH", "x86_64")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers[0].(dt.Agent).LayerContributor.Dependency.PURL).To(Equal("pkg:generic/dynatrace-one-agent@test-version?arch=amd64"))
+		})
+
+		it("fails on unsupported arch", func() {
+			t.Setenv("BP_ARCH", "riscv64")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("Dynatrace OneAgent is not available for arch riscv64")))
+		})
+
+		it("skips agent on unsupported arch with $BP_DYNATRACE_ARCH_POLICY", func() {
+			t.Setenv("BP_ARCH", "riscv64")
+			t.Setenv("BP_DYNATRACE_ARCH_POLICY", "skip")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers).To(BeEmpty())
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		it("fails on unsupported $BP_DYNATRACE_ARCH_POLICY", func() {
+			t.Setenv("BP_ARCH", "riscv64")
+			t.Setenv("BP_DYNATRACE_ARCH_POLICY", "test-policy")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("unsupported $BP_DYNATRACE_ARCH_POLICY test-policy")))
+		})
+	})
+
+	it("fails with invalid binding", func() {
+		ctx.Platform.Bindings[0].Secret["api_token"] = ctx.Platform.Bindings[0].Secret["api-token"]
+		delete(ctx.Platform.Bindings[0].Secret, "api-token")
+
+		_, err := dt.Build{}.Build(ctx)
+		Expect(err).To(MatchError(ContainSubstring("unknown key api_token, did you mean api-token?")))
+		Expect(server.ReceivedRequests()).To(BeEmpty())
+	})
+
+	it("prunes agent with $BP_DYNATRACE_PRUNE", func() {
+		t.Setenv("BP_DYNATRACE_PRUNE", "true")
+
+		result, err := dt.Build{}.Build(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		a := result.Layers[0].(dt.Agent)
+		Expect(a.Prune).To(BeTrue())
+		Expect(a.Technologies).To(Equal([]string{"java", "php"}))
+		Expect(a.LayerContributor.ExpectedMetadata).To(HaveKeyWithValue("prune", true))
+	})
+
+	context("$BP_DYNATRACE_TECHNOLOGIES", func() {
+		it("overrides plan entries", func() {
+			t.Setenv("BP_DYNATRACE_TECHNOLOGIES", "sdk, NodeJS,java,java")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			a := result.Layers[0].(dt.Agent)
+			Expect(a.LayerContributor.Dependency.URI).To(Equal(fmt.Sprintf("%s/api/v1/deployment/installer/agent/unix/paas/latest?bitness=64&skipMetadata=true&arch=x86&include=java&include=nodejs&include=sdk", server.URL())))
+			Expect(a.LayerContributor.ExpectedMetadata).To(HaveKeyWithValue("technologies", []string{"java", "nodejs", "sdk"}))
+		})
+
+		it("does not record technologies inferred from plan entries", func() {
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Layers[0].(dt.Agent).LayerContributor.ExpectedMetadata).NotTo(HaveKey("technologies"))
+		})
+
+		it("fails on unknown technology", func() {
+			t.Setenv("BP_DYNATRACE_TECHNOLOGIES", "java,cobol")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("unsupported technology cobol")))
+		})
+
+		it("fails on empty list", func() {
+			t.Setenv("BP_DYNATRACE_TECHNOLOGIES", " , ")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("no technologies listed")))
+		})
+	})
+
+	context("token preflight", func() {
+		it("looks up the API token", func() {
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(server.ReceivedRequests()[0].Method).To(Equal("POST"))
+			Expect(server.ReceivedRequests()[0].URL.Path).To(Equal("/api/v2/apiTokens/lookup"))
+			Expect(server.ReceivedRequests()[0].Header.Get("Authorization")).To(Equal("Api-Token test-api-token"))
+		})
+
+		it("fails on missing scopes", func() {
+			token["scopes"] = []string{"DataExport"}
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("missing the required scopes InstallerDownload")))
+
+			var missing *dt.MissingScopesError
+			Expect(errors.As(err, &missing)).To(BeTrue())
+			Expect(missing.Missing).To(Equal([]string{"InstallerDownload"}))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		it("fails on disabled token", func() {
+			token["enabled"] = false
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("Dynatrace API token test-token is disabled")))
+		})
+
+		it("fails on rejected token", func() {
+			tokenStatus = http.StatusUnauthorized
+			token = map[string]interface{}{"error": map[string]interface{}{"code": 401, "message": "Token test-api-token is invalid"}}
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("401: Token [REDACTED] is invalid")))
+
+			var apiErr *dt.APIError
+			Expect(errors.As(err, &apiErr)).To(BeTrue())
+			Expect(apiErr.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		it("continues if the lookup is unavailable", func() {
+			tokenStatus = http.StatusNotFound
+			token = map[string]interface{}{}
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers).To(HaveLen(3))
+		})
+	})
+
+	context("$BP_DYNATRACE_AGENT_VERSION", func() {
+		it.Before(func() {
+			server.SetHandler(0, ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/versions/unix/paas", "arch=x86"),
+				ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
+				ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"availableVersions": []string{
+					"1.283.123.20240101-120000",
+					"1.291.57.20240501-120000",
+					"1.291.101.20240601-120000",
+					"1.295.10.20240701-120000",
+				}}),
+			))
+		})
+
+		it("contributes exact version", func() {
+			t.Setenv("BP_DYNATRACE_AGENT_VERSION", "1.291.57.20240501-120000")
+			t.Setenv("BP_DYNATRACE_AGENT_SHA256", "test-sha256")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := result.Layers[0].(dt.Agent).LayerContributor.Dependency
+			Expect(dep.Version).To(Equal("1.291.57.20240501-120000"))
+			Expect(dep.URI).To(Equal(fmt.Sprintf("%s/api/v1/deployment/installer/agent/unix/paas/version/1.291.57.20240501-120000?bitness=64&skipMetadata=true&arch=x86&include=java&include=php", server.URL())))
+			Expect(dep.PURL).To(Equal("pkg:generic/dynatrace-one-agent@1.291.57.20240501-120000?arch=amd64"))
+		})
+
+		it("fails if exact version is pinned without SHA256", func() {
+			t.Setenv("BP_DYNATRACE_AGENT_VERSION", "1.291.57.20240501-120000")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError("$BP_DYNATRACE_AGENT_SHA256 must be set when $BP_DYNATRACE_AGENT_VERSION pins version 1.291.57.20240501-120000"))
+		})
+
+		it("contributes highest version matching constraint", func() {
+			t.Setenv("BP_DYNATRACE_AGENT_VERSION", "1.291.*")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := result.Layers[0].(dt.Agent).LayerContributor.Dependency
+			Expect(dep.Version).To(Equal("1.291.101.20240601-120000"))
+			Expect(dep.URI).To(HavePrefix(fmt.Sprintf("%s/api/v1/deployment/installer/agent/unix/paas/version/1.291.101.20240601-120000?", server.URL())))
+		})
+
+		it("contributes highest version matching range constraint", func() {
+			t.Setenv("BP_DYNATRACE_AGENT_VERSION", ">=1.280.0, <1.292.0")
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			dep := result.Layers[0].(dt.Agent).LayerContributor.Dependency
+			Expect(dep.Version).To(Equal("1.291.101.20240601-120000"))
+		})
+
+		it("fails if requested version is not available", func() {
+			t.Setenv("BP_DYNATRACE_AGENT_VERSION", "1.300.*")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("no Dynatrace OneAgent version matching 1.300.* is available for arch x86")))
+		})
+
+		it("fails if requested exact version is not available", func() {
+			t.Setenv("BP_DYNATRACE_AGENT_VERSION", "1.283.123.20240101-123456")
+
+			_, err := dt.Build{}.Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("no Dynatrace OneAgent version matching 1.283.123.20240101-123456 is available for arch x86")))
+			Expect(err).To(MatchError(ContainSubstring("available versions: ")))
+		})
+
+		it("uses latest", func() {
+			t.Setenv("BP_DYNATRACE_AGENT_VERSION", "latest")
+			server.SetHandler(0, ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/unix/paas/latest/metainfo"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"latestAgentVersion": "test-version"}),
+			))
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			verifyLayers(result.Layers, server.URL(), getExpectedDependency)
+		})
+	})
+
+	context("pre-staged archive", func() {
+		var path string
+
+		it.Before(func() {
+			path = t.TempDir()
+			Expect(os.WriteFile(filepath.Join(path, "oneagent.zip"), []byte{}, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(path, "metadata.toml"), []byte(`version = "1.291.57.20240501-120000"
+includes = ["java", "php"]
+`), 0644)).To(Succeed())
+
+			ctx.Platform.Bindings = append(ctx.Platform.Bindings, libcnb.Binding{
+				Name: "dynatrace-archive",
+				Type: "dynatrace-agent-archive",
+				Path: path,
+			})
+		})
+
+		it("contributes agent without calling the tenant", func() {
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+			Expect(result.Layers[0].(dt.Agent).LayerContributor.Dependency).To(Equal(libpak.BuildpackDependency{
+				ID:      "dynatrace-oneagent",
+				Name:    "Dynatrace OneAgent",
+				Version: "1.291.57.20240501-120000",
+				URI:     fmt.Sprintf("file://%s", filepath.Join(path, "oneagent.zip")),
+				Stacks:  []string{stackId},
+				PURL:    "pkg:generic/dynatrace-one-agent@1.291.57.20240501-120000?arch=amd64",
+				CPEs:    []string{"cpe:2.3:a:dynatrace:one-agent:1.291.57.20240501-120000:*:*:*:*:*:*:*"},
+			}))
+			verifyBOM(result.BOM)
+		})
+
+		it("contributes agent without tenant credentials", func() {
+			ctx.Platform.Bindings = ctx.Platform.Bindings[1:]
+
+			result, err := dt.Build{}.Build(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+			Expect(result.Layers[0].(dt.Agent).LayerContributor.Dependency.URI).
+				To(Equal(fmt.Sprintf("file://%s", filepath.Join(path, "oneagent.zip"))))
+			Expect(result.Layers[0].(dt.Agent).Client).To(BeNil())
+		})
+	})
+
 	context("python", func() {
 		it.Before(func() {
 			t.Setenv("BP_ARCH", "arm64")
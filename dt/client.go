@@ -0,0 +1,300 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paketo-buildpacks/libpak/bard"
+)
+
+// Client is an HTTP client for the Dynatrace API. Failed requests are retried with exponential backoff and jitter,
+// honoring Retry-After on 429 and 503 responses, until Deadline has passed.
+type Client struct {
+
+	// HTTPClient is the client used for individual attempts.
+	HTTPClient *http.Client
+
+	// Deadline bounds the total time spent on a request, including retries.
+	Deadline time.Duration
+
+	// InitialBackoff is the delay before the first retry. It doubles with each further retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff time.Duration
+
+	// Logger is the logger used to report retries.
+	Logger bard.Logger
+}
+
+// NewClient creates a new instance where each attempt times out after timeout and requests give up after deadline.
+// tlsConfig is optional and used for all connections.
+func NewClient(timeout time.Duration, deadline time.Duration, tlsConfig *tls.Config) Client {
+	return Client{
+		HTTPClient: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+		Deadline:       deadline,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// NewClientFromEnvironment creates a new instance with the timeout and deadline, in seconds, read from the
+// <prefix>_DYNATRACE_HTTP_TIMEOUT and <prefix>_DYNATRACE_HTTP_DEADLINE environment variables.
+func NewClientFromEnvironment(prefix string, timeout int, deadline int, tlsConfig *tls.Config) (Client, error) {
+	t, err := secondsFromEnvironment(fmt.Sprintf("%s_DYNATRACE_HTTP_TIMEOUT", prefix), timeout)
+	if err != nil {
+		return Client{}, err
+	}
+
+	d, err := secondsFromEnvironment(fmt.Sprintf("%s_DYNATRACE_HTTP_DEADLINE", prefix), deadline)
+	if err != nil {
+		return Client{}, err
+	}
+
+	return NewClient(t, d, tlsConfig), nil
+}
+
+// Do sends the request, retrying on transport errors, 429 and 5xx responses. The last response or error is returned
+// once the next attempt would start after the deadline. Without a deadline, the request is attempted only once.
+// Certificate verification errors are returned immediately as retrying cannot resolve them.
+func (c Client) Do(req *http.Request) (*http.Response, error) {
+	deadline := time.Now().Add(c.Deadline)
+
+	backoff := c.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.HTTPClient.Do(req)
+
+		if err == nil && !retryable(resp.StatusCode) {
+			return resp, nil
+		} else if err != nil && certificateError(err) {
+			return nil, err
+		}
+
+		wait := jitter(backoff)
+		if err == nil {
+			if r, ok := retryAfter(resp); ok {
+				wait = r
+			}
+		}
+
+		if c.Deadline <= 0 || time.Now().Add(wait).After(deadline) {
+			if err != nil {
+				return nil, fmt.Errorf("giving up after %d attempts\n%w", attempt, err)
+			}
+			return resp, nil
+		}
+
+		if err != nil {
+			c.Logger.Bodyf("Request to %s failed, retrying in %s: %s", req.URL.Redacted(), wait, err)
+		} else {
+			c.Logger.Bodyf("Request to %s returned %d, retrying in %s", req.URL.Redacted(), resp.StatusCode, wait)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, fmt.Errorf("giving up after %d attempts\n%w", attempt, req.Context().Err())
+		}
+
+		if backoff *= 2; backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("unable to replay request body\n%w", err)
+			}
+		}
+	}
+}
+
+// certificateError reports whether err is caused by a certificate of the server failing verification.
+func certificateError(err error) bool {
+	var (
+		verification *tls.CertificateVerificationError
+		authority    x509.UnknownAuthorityError
+		hostname     x509.HostnameError
+		invalid      x509.CertificateInvalidError
+	)
+
+	return errors.As(err, &verification) || errors.As(err, &authority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
+
+// GetJSON requests uri authenticated with apiToken and decodes the JSON response into v.
+func (c Client) GetJSON(uri string, apiToken string, userAgent string, v interface{}) error {
+	return c.requestJSON("GET", uri, apiToken, userAgent, nil, v)
+}
+
+// PostJSON posts body encoded as JSON to uri authenticated with apiToken and decodes the JSON response into v.
+func (c Client) PostJSON(uri string, apiToken string, userAgent string, body interface{}, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("unable to encode payload\n%w", err)
+	}
+
+	return c.requestJSON("POST", uri, apiToken, userAgent, b, v)
+}
+
+func (c Client) requestJSON(method string, uri string, apiToken string, userAgent string, body []byte, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, uri, r)
+	if err != nil {
+		return fmt.Errorf("unable to create new %s request for %s\n%w", method, uri, err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Api-Token %s", apiToken))
+	req.Header.Set("User-Agent", userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to request %s\n%w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return NewAPIError(resp, apiToken)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode payload\n%w", err)
+	}
+
+	return nil
+}
+
+// APIError is returned for non-2xx responses of the Dynatrace API. It carries the error message of the response body
+// with the API token redacted.
+type APIError struct {
+	URI        string
+	StatusCode int
+	Message    string
+}
+
+// NewAPIError creates a new instance from the response, redacting apiToken from the error message.
+func NewAPIError(resp *http.Response, apiToken string) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode}
+	if resp.Request != nil {
+		e.URI = resp.Request.URL.Redacted()
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return e
+	}
+
+	raw := struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(b, &raw); err == nil && raw.Error.Message != "" {
+		e.Message = raw.Error.Message
+	} else {
+		e.Message = strings.TrimSpace(string(b))
+	}
+
+	if len(e.Message) > 512 {
+		e.Message = e.Message[:512] + "..."
+	}
+
+	if apiToken != "" {
+		e.Message = strings.ReplaceAll(e.Message, apiToken, "[REDACTED]")
+	}
+
+	return e
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("request to %s failed: %d", e.URI, e.StatusCode)
+	}
+	return fmt.Sprintf("request to %s failed: %d: %s", e.URI, e.StatusCode, e.Message)
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	s := resp.Header.Get("Retry-After")
+	if s == "" {
+		return 0, false
+	}
+
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(s); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// jitter returns a random duration between half of and the full backoff.
+func jitter(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func secondsFromEnvironment(name string, def int) (time.Duration, error) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return time.Duration(def) * time.Second, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("unable to convert %s=%s to integer\n%w", name, s, err)
+	}
+
+	return time.Duration(n) * time.Second, nil
+}
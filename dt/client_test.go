@@ -0,0 +1,179 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testClient(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		client dt.Client
+		server *ghttp.Server
+		result map[string]interface{}
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		server = ghttp.NewServer()
+
+		client = dt.NewClient(time.Second, 5*time.Second, nil)
+		client.InitialBackoff = time.Millisecond
+		client.MaxBackoff = 10 * time.Millisecond
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("requests with token and user agent", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/test"),
+			ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
+			ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"test-key": "test-value"}),
+		))
+
+		Expect(client.GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version", &result)).To(Succeed())
+		Expect(result).To(Equal(map[string]interface{}{"test-key": "test-value"}))
+	})
+
+	it("retries server errors", func() {
+		server.AppendHandlers(
+			ghttp.RespondWith(http.StatusBadGateway, ""),
+			ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"test-key": "test-value"}),
+		)
+
+		Expect(client.GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version", &result)).To(Succeed())
+		Expect(server.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	it("honors Retry-After", func() {
+		server.AppendHandlers(
+			ghttp.RespondWith(http.StatusTooManyRequests, "", http.Header{"Retry-After": []string{"1"}}),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"test-key": "test-value"}),
+		)
+
+		start := time.Now()
+		Expect(client.GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version", &result)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+	})
+
+	it("does not retry client errors", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusUnauthorized, ""))
+
+		err := client.GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version", &result)
+		Expect(err).To(MatchError(ContainSubstring("failed: 401")))
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	it("returns API error with redacted message", func() {
+		server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusForbidden, map[string]interface{}{
+			"error": map[string]interface{}{"code": 403, "message": "Token test-api-token is missing required scope"},
+		}))
+
+		err := client.GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version", &result)
+
+		var apiErr *dt.APIError
+		Expect(errors.As(err, &apiErr)).To(BeTrue())
+		Expect(apiErr.StatusCode).To(Equal(http.StatusForbidden))
+		Expect(apiErr.Message).To(Equal("Token [REDACTED] is missing required scope"))
+		Expect(err).To(MatchError(fmt.Sprintf("request to %s/test failed: 403: Token [REDACTED] is missing required scope", server.URL())))
+	})
+
+	it("returns API error with plain body", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, " not found \n"))
+
+		err := client.GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version", &result)
+		Expect(err).To(MatchError(fmt.Sprintf("request to %s/test failed: 404: not found", server.URL())))
+	})
+
+	it("posts JSON and replays the body on retries", func() {
+		server.AppendHandlers(
+			ghttp.RespondWith(http.StatusBadGateway, ""),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/test"),
+				ghttp.VerifyContentType("application/json"),
+				ghttp.VerifyJSON(`{"test-key":"test-value"}`),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"test-key": "test-value"}),
+			),
+		)
+
+		Expect(client.PostJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version",
+			map[string]string{"test-key": "test-value"}, &result)).To(Succeed())
+		Expect(result).To(Equal(map[string]interface{}{"test-key": "test-value"}))
+	})
+
+	it("gives up once Retry-After exceeds the deadline", func() {
+		client.Deadline = 100 * time.Millisecond
+		server.AppendHandlers(ghttp.RespondWith(http.StatusServiceUnavailable, "", http.Header{"Retry-After": []string{"60"}}))
+
+		err := client.GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version", &result)
+		Expect(err).To(MatchError(ContainSubstring(": 503")))
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	it("gives up at the deadline", func() {
+		client.Deadline = 200 * time.Millisecond
+		server.RouteToHandler("GET", "/test", ghttp.RespondWith(http.StatusInternalServerError, ""))
+
+		err := client.GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-api-token", "test-id/test-version", &result)
+		Expect(err).To(MatchError(ContainSubstring(": 500")))
+		Expect(len(server.ReceivedRequests())).To(BeNumerically(">", 1))
+	})
+
+	it("does not retry certificate errors", func() {
+		tlsServer := ghttp.NewTLSServer()
+		defer tlsServer.Close()
+		tlsServer.RouteToHandler("GET", "/test", ghttp.RespondWith(http.StatusOK, "{}"))
+
+		start := time.Now()
+		err := client.GetJSON(fmt.Sprintf("%s/test", tlsServer.URL()), "test-api-token", "test-id/test-version", &result)
+		Expect(err).To(MatchError(ContainSubstring("certificate")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	it("reads timeouts from the environment", func() {
+		t.Setenv("BPL_DYNATRACE_HTTP_TIMEOUT", "3")
+		t.Setenv("BPL_DYNATRACE_HTTP_DEADLINE", "7")
+
+		c, err := dt.NewClientFromEnvironment("BPL", 10, 30, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.HTTPClient.Timeout).To(Equal(3 * time.Second))
+		Expect(c.Deadline).To(Equal(7 * time.Second))
+	})
+
+	it("fails on invalid timeout", func() {
+		t.Setenv("BP_DYNATRACE_HTTP_TIMEOUT", "abc")
+
+		_, err := dt.NewClientFromEnvironment("BP", 30, 120, nil)
+		Expect(err).To(MatchError(ContainSubstring("unable to convert BP_DYNATRACE_HTTP_TIMEOUT=abc to integer")))
+	})
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+)
+
+// ConnectionInfo describes how OneAgent connects to the tenant.
+type ConnectionInfo struct {
+	TenantUUID             string   `json:"tenantUUID"`
+	TenantToken            string   `json:"tenantToken"`
+	CommunicationEndpoints []string `json:"communicationEndpoints"`
+	NetworkZone            string   `json:"networkZone,omitempty"`
+}
+
+// GetConnectionInfo requests the connection info from the tenant, with the endpoints ordered for networkZone if set.
+func GetConnectionInfo(client Client, binding libcnb.Binding, userAgent string, networkZone string) (ConnectionInfo, error) {
+	base, err := BaseURI(binding)
+	if err != nil {
+		return ConnectionInfo{}, fmt.Errorf("unable to determine Dynatrace API URL\n%w", err)
+	}
+
+	uri := fmt.Sprintf("%s/v1/deployment/installer/agent/connectioninfo", base)
+	if networkZone != "" {
+		uri = fmt.Sprintf("%s?networkZone=%s", uri, url.QueryEscape(networkZone))
+	}
+
+	var c ConnectionInfo
+	if err := client.GetJSON(uri, APIToken(binding), userAgent, &c); err != nil {
+		return ConnectionInfo{}, err
+	}
+	c.NetworkZone = networkZone
+
+	return c, nil
+}
+
+// Environment returns the OneAgent environment variables for the connection info.
+func (c ConnectionInfo) Environment() map[string]string {
+	e := map[string]string{
+		"DT_TENANT":           c.TenantUUID,
+		"DT_TENANTTOKEN":      c.TenantToken,
+		"DT_CONNECTION_POINT": strings.Join(c.CommunicationEndpoints, ";"),
+	}
+
+	if c.NetworkZone != "" {
+		e["DT_NETWORK_ZONE"] = c.NetworkZone
+	}
+
+	return e
+}
+
+// Digest returns a digest of the connection info that can be recorded without exposing the tenant token.
+func (c ConnectionInfo) Digest() string {
+	e := c.Environment()
+
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(h, "%s=%s\n", k, e[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
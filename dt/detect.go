@@ -18,10 +18,12 @@ package dt
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/buildpacks/libcnb"
+	"github.com/paketo-buildpacks/libpak"
 	"github.com/paketo-buildpacks/libpak/bard"
-	"github.com/paketo-buildpacks/libpak/bindings"
+	"github.com/paketo-buildpacks/libpak/sherpa"
 )
 
 type Detect struct {
@@ -29,12 +31,39 @@ type Detect struct {
 }
 
 func (d Detect) Detect(context libcnb.DetectContext) (libcnb.DetectResult, error) {
-	_, ok, err := bindings.ResolveOne(context.Platform.Bindings, IsDynatraceBinding)
+	if _, ok := os.LookupEnv("BP_DYNATRACE_ENABLED"); ok {
+		enabled, err := sherpa.ResolveBoolErr("BP_DYNATRACE_ENABLED")
+		if err != nil {
+			return libcnb.DetectResult{}, fmt.Errorf("unable to resolve $BP_DYNATRACE_ENABLED\n%w", err)
+		} else if !enabled {
+			d.Logger.Info("SKIPPED: Dynatrace is disabled by $BP_DYNATRACE_ENABLED")
+			return libcnb.DetectResult{Pass: false}, nil
+		}
+	}
+
+	cr, err := libpak.NewConfigurationResolver(context.Buildpack, nil)
+	if err != nil {
+		return libcnb.DetectResult{}, fmt.Errorf("unable to create configuration resolver\n%w", err)
+	}
+
+	// a pre-staged agent is installed without tenant credentials, the binding only matters at launch time
+	_, offline, err := ResolveAgentArchive(context.Platform.Bindings, cr)
 	if err != nil {
-		return libcnb.DetectResult{}, fmt.Errorf("unable to resolve binding Dynatrace\n%w", err)
-	} else if !ok {
-		d.Logger.Info("SKIPPED: No binding for 'Dynatrace' found (type or name)")
-		return libcnb.DetectResult{Pass: false}, nil
+		return libcnb.DetectResult{}, fmt.Errorf("unable to resolve Dynatrace OneAgent archive\n%w", err)
+	}
+
+	if !offline {
+		b, ok, err := ResolveBinding(context.Platform.Bindings, "BP")
+		if err != nil {
+			return libcnb.DetectResult{}, fmt.Errorf("unable to resolve binding Dynatrace\n%w", err)
+		} else if !ok {
+			d.Logger.Info("SKIPPED: No binding for 'Dynatrace' found (type or name)")
+			return libcnb.DetectResult{Pass: false}, nil
+		}
+
+		if err := ValidateBinding(b); err != nil {
+			return libcnb.DetectResult{}, err
+		}
 	}
 
 	return libcnb.DetectResult{
@@ -17,6 +17,8 @@
 package dt_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/buildpacks/libcnb"
@@ -142,6 +144,8 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 
 		ctx    libcnb.DetectContext
 		detect dt.Detect
+
+		secret = map[string]string{"api-token": "test-api-token", "environment-id": "test-id"}
 	)
 
 	it("fails detection without service", func() {
@@ -154,7 +158,7 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 
 	it("passes with service of type dynatrace", func() {
 		ctx.Platform.Bindings = libcnb.Bindings{
-			{Name: "test-service", Type: "Dynatrace"},
+			{Name: "test-service", Type: "Dynatrace", Secret: secret},
 		}
 
 		actualResult, err := detect.Detect(ctx)
@@ -166,7 +170,7 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 
 	it("passes with service with name dynatrace", func() {
 		ctx.Platform.Bindings = libcnb.Bindings{
-			{Name: "Dynatrace", Type: "user-provided"},
+			{Name: "Dynatrace", Type: "user-provided", Secret: secret},
 		}
 
 		actualResult, err := detect.Detect(ctx)
@@ -178,11 +182,76 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 
 	it("fails with multiple matching services provided", func() {
 		ctx.Platform.Bindings = libcnb.Bindings{
-			{Name: "Dynatrace", Type: "user-provided"},
-			{Name: "provided", Type: "Dynatrace"},
+			{Name: "Dynatrace", Type: "user-provided", Secret: secret},
+			{Name: "provided", Type: "Dynatrace", Secret: secret},
 		}
 
 		_, err := detect.Detect(ctx)
 		Expect(err).To(MatchError(ContainSubstring("unable to resolve")))
 	})
+
+	it("fails with invalid binding", func() {
+		ctx.Platform.Bindings = libcnb.Bindings{
+			{Name: "test-service", Type: "Dynatrace", Secret: map[string]string{"api_token": "test-api-token", "environment-id": "test-id"}},
+		}
+
+		_, err := detect.Detect(ctx)
+		Expect(err).To(MatchError(ContainSubstring("unknown key api_token, did you mean api-token?")))
+	})
+
+	it("passes with credentials in the environment", func() {
+		t.Setenv("BP_DYNATRACE_ENVIRONMENT_ID", "test-id")
+		t.Setenv("BP_DYNATRACE_API_TOKEN", "test-api-token")
+
+		Expect(detect.Detect(ctx)).To(Equal(expectedResult))
+	})
+
+	it("passes with pre-staged archive without service", func() {
+		path := t.TempDir()
+		Expect(os.WriteFile(filepath.Join(path, "oneagent.zip"), []byte{}, 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(path, "metadata.toml"), []byte(`version = "1.291.57.20240501-120000"`), 0644)).To(Succeed())
+		t.Setenv("BP_DYNATRACE_AGENT_ARCHIVE", path)
+
+		Expect(detect.Detect(ctx)).To(Equal(expectedResult))
+	})
+
+	context("$BP_DYNATRACE_ENABLED", func() {
+		it.Before(func() {
+			ctx.Platform.Bindings = libcnb.Bindings{
+				{Name: "test-service", Type: "Dynatrace", Secret: secret},
+			}
+		})
+
+		it("fails detection if disabled", func() {
+			t.Setenv("BP_DYNATRACE_ENABLED", "false")
+
+			Expect(detect.Detect(ctx)).To(Equal(libcnb.DetectResult{}))
+		})
+
+		it("passes if enabled", func() {
+			t.Setenv("BP_DYNATRACE_ENABLED", "true")
+
+			Expect(detect.Detect(ctx)).To(Equal(expectedResult))
+		})
+
+		it("returns error on invalid value", func() {
+			t.Setenv("BP_DYNATRACE_ENABLED", "test-value")
+
+			_, err := detect.Detect(ctx)
+			Expect(err).To(MatchError(ContainSubstring("unable to resolve $BP_DYNATRACE_ENABLED")))
+		})
+	})
+
+	it("passes with multiple matching services and $BP_DYNATRACE_BINDING_NAME", func() {
+		t.Setenv("BP_DYNATRACE_BINDING_NAME", "provided")
+		ctx.Platform.Bindings = libcnb.Bindings{
+			{Name: "Dynatrace", Type: "user-provided", Secret: secret},
+			{Name: "provided", Type: "Dynatrace", Secret: secret},
+		}
+
+		actualResult, err := detect.Detect(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(actualResult).To(Equal(expectedResult))
+	})
 }
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2018-2026 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/buildpacks/libcnb"
+	"github.com/paketo-buildpacks/libpak"
+	"github.com/paketo-buildpacks/libpak/bard"
+	"github.com/paketo-buildpacks/libpak/sherpa"
+)
+
+// Doctor contributes the doctor command of the buildpack to a launch layer, so that it is on $PATH in the application
+// image for diagnosing the connectivity of OneAgent.
+type Doctor struct {
+	LayerContributor libpak.LayerContributor
+	Logger           bard.Logger
+	Path             string
+}
+
+func NewDoctor(buildpack libcnb.Buildpack) Doctor {
+	expected := map[string]interface{}{"buildpackInfo": buildpack.Info}
+
+	return Doctor{
+		LayerContributor: libpak.NewLayerContributor("Dynatrace Doctor", expected, libcnb.LayerTypes{
+			Launch: true,
+		}),
+		Path: filepath.Join(buildpack.Path, "bin", "doctor"),
+	}
+}
+
+func (d Doctor) Contribute(layer libcnb.Layer) (libcnb.Layer, error) {
+	d.LayerContributor.Logger = d.Logger
+
+	return d.LayerContributor.Contribute(layer, func() (libcnb.Layer, error) {
+		in, err := os.Open(d.Path)
+		if err != nil {
+			return libcnb.Layer{}, fmt.Errorf("unable to open %s\n%w", d.Path, err)
+		}
+		defer in.Close()
+
+		out := filepath.Join(layer.Path, "bin", "dynatrace-doctor")
+		d.Logger.Bodyf("Copying to %s", out)
+		if err := sherpa.CopyFile(in, out); err != nil {
+			return libcnb.Layer{}, fmt.Errorf("unable to copy %s to %s\n%w", d.Path, out, err)
+		}
+
+		return layer, nil
+	})
+}
+
+func (Doctor) Name() string {
+	return "doctor"
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018-2026 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testDoctor(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		buildpack libcnb.Buildpack
+		layer     libcnb.Layer
+	)
+
+	it.Before(func() {
+		buildpack.Path = t.TempDir()
+		buildpack.Info = libcnb.BuildpackInfo{ID: "test-id", Version: "test-version"}
+
+		Expect(os.MkdirAll(filepath.Join(buildpack.Path, "bin"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildpack.Path, "bin", "doctor"), []byte("test-doctor"), 0755)).To(Succeed())
+
+		layers := libcnb.Layers{Path: t.TempDir()}
+		var err error
+		layer, err = layers.Layer("test-layer")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	it("contributes doctor to launch layer", func() {
+		layer, err := dt.NewDoctor(buildpack).Contribute(layer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(layer.Launch).To(BeTrue())
+		Expect(os.ReadFile(filepath.Join(layer.Path, "bin", "dynatrace-doctor"))).To(Equal([]byte("test-doctor")))
+
+		info, err := os.Stat(filepath.Join(layer.Path, "bin", "dynatrace-doctor"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+	})
+}
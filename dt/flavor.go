@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	FlavorDefault     = "default"
+	FlavorMusl        = "musl"
+	FlavorMultiDistro = "multidistro"
+)
+
+// Libc describes the C library of the run image.
+type Libc int
+
+const (
+	LibcUnknown Libc = iota
+	LibcGlibc
+	LibcMusl
+)
+
+// LibcForTarget infers the C library of the run image from $CNB_TARGET_DISTRO_NAME or, if not set, the stack id.
+func LibcForTarget(stackID string) Libc {
+	if d, ok := os.LookupEnv("CNB_TARGET_DISTRO_NAME"); ok && d != "" {
+		if strings.EqualFold(d, "alpine") {
+			return LibcMusl
+		}
+		return LibcGlibc
+	}
+
+	s := strings.ToLower(stackID)
+	for _, m := range []string{"alpine", "musl"} {
+		if strings.Contains(s, m) {
+			return LibcMusl
+		}
+	}
+	for _, g := range []string{"bionic", "focal", "jammy", "noble", "ubuntu", "debian", "ubi", "rhel"} {
+		if strings.Contains(s, g) {
+			return LibcGlibc
+		}
+	}
+
+	return LibcUnknown
+}
+
+// ResolveFlavor returns the OneAgent flavor to download. An explicitly requested flavor is validated, otherwise musl is
+// chosen for musl based run images and default for everything else. A warning is returned if the flavor does not
+// match the run image.
+func ResolveFlavor(requested string, libc Libc) (string, string, error) {
+	flavor := strings.ToLower(strings.TrimSpace(requested))
+
+	switch flavor {
+	case "":
+		if libc == LibcMusl {
+			return FlavorMusl, "", nil
+		}
+		return FlavorDefault, "", nil
+	case FlavorDefault:
+		if libc == LibcMusl {
+			return flavor, "flavor default is built for glibc but the run image appears to be musl based", nil
+		}
+	case FlavorMusl:
+		if libc == LibcGlibc {
+			return flavor, "flavor musl is built for musl but the run image appears to be glibc based", nil
+		}
+	case FlavorMultiDistro:
+	default:
+		return "", "", fmt.Errorf("unsupported flavor %s, must be one of %s, %s or %s", requested, FlavorDefault, FlavorMusl, FlavorMultiDistro)
+	}
+
+	return flavor, "", nil
+}
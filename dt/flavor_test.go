@@ -0,0 +1,78 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testFlavor(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	context("LibcForTarget", func() {
+		it("uses $CNB_TARGET_DISTRO_NAME", func() {
+			t.Setenv("CNB_TARGET_DISTRO_NAME", "alpine")
+			Expect(dt.LibcForTarget("io.buildpacks.stacks.jammy")).To(Equal(dt.LibcMusl))
+
+			t.Setenv("CNB_TARGET_DISTRO_NAME", "ubuntu")
+			Expect(dt.LibcForTarget("*")).To(Equal(dt.LibcGlibc))
+		})
+
+		it("uses stack id", func() {
+			Expect(dt.LibcForTarget("io.buildpacks.stacks.alpine")).To(Equal(dt.LibcMusl))
+			Expect(dt.LibcForTarget("io.buildpacks.stacks.jammy")).To(Equal(dt.LibcGlibc))
+			Expect(dt.LibcForTarget("*")).To(Equal(dt.LibcUnknown))
+		})
+	})
+
+	context("ResolveFlavor", func() {
+		it("infers flavor from libc", func() {
+			Expect(dt.ResolveFlavor("", dt.LibcMusl)).To(Equal(dt.FlavorMusl))
+			Expect(dt.ResolveFlavor("", dt.LibcGlibc)).To(Equal(dt.FlavorDefault))
+			Expect(dt.ResolveFlavor("", dt.LibcUnknown)).To(Equal(dt.FlavorDefault))
+		})
+
+		it("uses requested flavor", func() {
+			f, w, err := dt.ResolveFlavor("MultiDistro", dt.LibcMusl)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f).To(Equal(dt.FlavorMultiDistro))
+			Expect(w).To(BeEmpty())
+		})
+
+		it("warns on mismatch", func() {
+			_, w, err := dt.ResolveFlavor("default", dt.LibcMusl)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w).To(ContainSubstring("appears to be musl based"))
+
+			_, w, err = dt.ResolveFlavor("musl", dt.LibcGlibc)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w).To(ContainSubstring("appears to be glibc based"))
+		})
+
+		it("fails on unsupported flavor", func() {
+			_, _, err := dt.ResolveFlavor("test-flavor", dt.LibcUnknown)
+			Expect(err).To(MatchError("unsupported flavor test-flavor, must be one of default, musl or multidistro"))
+		})
+	})
+}
@@ -26,9 +26,20 @@ import (
 func TestUnit(t *testing.T) {
 	suite := spec.New("dynatrace", spec.Report(report.Terminal{}))
 	suite("Agent", testAgent)
+	suite("AgentArchive", testAgentArchive)
 	suite("BaseURI", testBaseURI)
 	suite("APIToken", testAPIToken)
+	suite("Architecture", testArchitecture)
 	suite("Build", testBuild)
+	suite("Client", testClient)
 	suite("Detect", testDetect)
+	suite("Doctor", testDoctor)
+	suite("Flavor", testFlavor)
+	suite("Prune", testPrune)
+	suite("ResolveBinding", testResolveBinding)
+	suite("TLSConfig", testTLSConfig)
+	suite("TokenScopes", testTokenScopes)
+	suite("ValidateBinding", testValidateBinding)
+	suite("VCAPServicesBindings", testVCAPServicesBindings)
 	suite.Run(t)
 }
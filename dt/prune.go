@@ -0,0 +1,219 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PrunableTechnologies are the technologies whose code modules are removed when not included. Technologies not listed
+// here, such as the process module, are always kept.
+var PrunableTechnologies = []string{"apache", "dotnet", "go", "java", "nginx", "nodejs", "php", "python", "sdk"}
+
+type agentManifest struct {
+	Technologies map[string]map[string][]struct {
+		Path string `json:"path"`
+	} `json:"technologies"`
+}
+
+// PruneUnused removes the code modules of technologies that are not included and of other architectures and C libraries
+// from the expanded agent, using the manifest.json shipped with it, as well as the 32-bit libraries. It returns the
+// removed paths, relative to root, and the number of bytes saved.
+func (a Agent) PruneUnused(root string) ([]string, int64, error) {
+	candidates := make(map[string]bool)
+
+	m, err := readAgentManifest(filepath.Join(root, "manifest.json"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keep := make(map[string]bool)
+	for t, arches := range m.Technologies {
+		// only tell C libraries apart if the technology ships modules for both
+		musl, glibc := false, false
+		for arch := range arches {
+			if isArchitectureSpecific(arch) {
+				if strings.Contains(strings.ToLower(arch), "musl") {
+					musl = true
+				} else {
+					glibc = true
+				}
+			}
+		}
+
+		for arch, files := range arches {
+			needed := a.technologyNeeded(t) && a.architectureNeeded(arch, musl && glibc)
+			for _, f := range files {
+				p := filepath.Clean(filepath.FromSlash(f.Path))
+				if needed {
+					keep[p] = true
+				} else {
+					candidates[p] = true
+				}
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "agent", "lib64")); err == nil {
+		candidates[filepath.Join("agent", "lib")] = true
+	}
+
+	var paths []string
+	for p := range candidates {
+		if !keep[p] && !strings.HasPrefix(p, "..") && !filepath.IsAbs(p) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var (
+		removed []string
+		saved   int64
+	)
+	for _, p := range paths {
+		file := filepath.Join(root, p)
+
+		size, err := diskUsage(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		if err := os.RemoveAll(file); err != nil {
+			return nil, 0, fmt.Errorf("unable to remove %s\n%w", file, err)
+		}
+		removeEmptyParents(root, filepath.Dir(file))
+
+		removed = append(removed, filepath.ToSlash(p))
+		saved += size
+	}
+
+	return removed, saved, nil
+}
+
+func (a Agent) technologyNeeded(technology string) bool {
+	if len(a.Technologies) == 0 {
+		return true
+	}
+
+	for _, t := range a.Technologies {
+		if t == "all" || t == technology {
+			return true
+		}
+	}
+
+	for _, t := range PrunableTechnologies {
+		if t == technology {
+			return false
+		}
+	}
+
+	return true
+}
+
+// architectureNeeded reports whether the code modules for an architecture key of the manifest, such as linux-x86-64 or
+// linux-musl-aarch64, can be loaded on the run image. Keys not naming an architecture are always needed and the C
+// library is only considered if byLibc is set.
+func (a Agent) architectureNeeded(arch string, byLibc bool) bool {
+	if !isArchitectureSpecific(arch) {
+		return true
+	}
+
+	arch = strings.ToLower(arch)
+
+	matches := false
+	for _, t := range architectureTokens[a.Architecture] {
+		if strings.Contains(arch, t) {
+			matches = true
+		}
+	}
+
+	return matches && (!byLibc || strings.Contains(arch, "musl") == a.Musl)
+}
+
+func isArchitectureSpecific(arch string) bool {
+	arch = strings.ToLower(arch)
+
+	for _, tokens := range architectureTokens {
+		for _, t := range tokens {
+			if strings.Contains(arch, t) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+var architectureTokens = map[string][]string{
+	"amd64":   {"x86"},
+	"arm64":   {"aarch64", "arm"},
+	"ppc64le": {"ppc"},
+	"s390x":   {"s390"},
+}
+
+func readAgentManifest(file string) (agentManifest, error) {
+	var m agentManifest
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return m, fmt.Errorf("unable to read %s\n%w", file, err)
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("unable to decode %s\n%w", file, err)
+	}
+
+	return m, nil
+}
+
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			i, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += i.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func removeEmptyParents(root string, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testPrune(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		root string
+	)
+
+	write := func(path string, size int) {
+		file := filepath.Join(root, path)
+		Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(Succeed())
+		Expect(os.WriteFile(file, make([]byte, size), 0644)).To(Succeed())
+	}
+
+	it.Before(func() {
+		root = t.TempDir()
+
+		write("agent/lib64/liboneagentproc.so", 1)
+		write("agent/lib64/liboneagentjava.so", 2)
+		write("agent/lib64/liboneagentnodejs.so", 4)
+		write("agent/bin/linux-musl-x86-64/liboneagentjava.so", 8)
+		write("agent/bin/linux-aarch64/liboneagentjava.so", 16)
+		write("agent/lib/liboneagentproc.so", 32)
+		write("agent/conf/ruxitagentproc.conf", 64)
+
+		Expect(os.WriteFile(filepath.Join(root, "manifest.json"), []byte(`{
+			"technologies": {
+				"process": {
+					"linux-x86-64": [{"path": "agent/lib64/liboneagentproc.so"}]
+				},
+				"java": {
+					"linux-x86-64": [{"path": "agent/lib64/liboneagentjava.so"}],
+					"linux-musl-x86-64": [{"path": "agent/bin/linux-musl-x86-64/liboneagentjava.so"}],
+					"linux-aarch64": [{"path": "agent/bin/linux-aarch64/liboneagentjava.so"}]
+				},
+				"nodejs": {
+					"linux-x86-64": [{"path": "agent/lib64/liboneagentnodejs.so"}]
+				}
+			}
+		}`), 0644)).To(Succeed())
+	})
+
+	it("removes unused technologies, architectures and 32-bit libraries", func() {
+		removed, saved, err := dt.Agent{Architecture: "amd64", Technologies: []string{"java"}}.PruneUnused(root)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(removed).To(Equal([]string{
+			"agent/bin/linux-aarch64/liboneagentjava.so",
+			"agent/bin/linux-musl-x86-64/liboneagentjava.so",
+			"agent/lib",
+			"agent/lib64/liboneagentnodejs.so",
+		}))
+		Expect(saved).To(Equal(int64(60)))
+
+		Expect(filepath.Join(root, "agent/lib64/liboneagentproc.so")).To(BeARegularFile())
+		Expect(filepath.Join(root, "agent/lib64/liboneagentjava.so")).To(BeARegularFile())
+		Expect(filepath.Join(root, "agent/conf/ruxitagentproc.conf")).To(BeARegularFile())
+		Expect(filepath.Join(root, "agent/bin")).NotTo(BeADirectory())
+	})
+
+	it("keeps musl libraries for musl run images", func() {
+		removed, _, err := dt.Agent{Architecture: "amd64", Musl: true, Technologies: []string{"java", "nodejs"}}.PruneUnused(root)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(removed).To(ConsistOf(
+			"agent/bin/linux-aarch64/liboneagentjava.so",
+			"agent/lib",
+			"agent/lib64/liboneagentjava.so",
+		))
+		Expect(filepath.Join(root, "agent/bin/linux-musl-x86-64/liboneagentjava.so")).To(BeARegularFile())
+		Expect(filepath.Join(root, "agent/lib64/liboneagentproc.so")).To(BeARegularFile())
+	})
+
+	it("keeps all technologies when all are included", func() {
+		removed, _, err := dt.Agent{Architecture: "amd64", Technologies: []string{"all"}}.PruneUnused(root)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(removed).To(Equal([]string{
+			"agent/bin/linux-aarch64/liboneagentjava.so",
+			"agent/bin/linux-musl-x86-64/liboneagentjava.so",
+			"agent/lib",
+		}))
+	})
+
+	it("removes only 32-bit libraries without manifest", func() {
+		Expect(os.Remove(filepath.Join(root, "manifest.json"))).To(Succeed())
+
+		removed, saved, err := dt.Agent{Architecture: "amd64", Technologies: []string{"java"}}.PruneUnused(root)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(removed).To(Equal([]string{"agent/lib"}))
+		Expect(saved).To(Equal(int64(32)))
+	})
+}
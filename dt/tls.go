@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	// CACertificateKey is the binding key of the PEM encoded CA certificates trusted for Dynatrace connections.
+	CACertificateKey = "ca.crt"
+
+	// ClientCertificateKey is the binding key of the PEM encoded client certificate used for mTLS.
+	ClientCertificateKey = "tls.crt"
+
+	// ClientKeyKey is the binding key of the PEM encoded private key of the client certificate.
+	ClientKeyKey = "tls.key"
+)
+
+// TLSConfig returns the TLS configuration for connections to the tenant and ActiveGates built from the ca.crt,
+// tls.crt and tls.key keys of the binding. The CA certificates are trusted in addition to the system roots. nil is
+// returned if none of the keys are set.
+func TLSConfig(binding libcnb.Binding) (*tls.Config, error) {
+	ca, hasCA := binding.Secret[CACertificateKey]
+	crt, hasCrt := binding.Secret[ClientCertificateKey]
+	key, hasKey := binding.Secret[ClientKeyKey]
+
+	if !hasCA && !hasCrt && !hasKey {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if hasCA {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, fmt.Errorf("unable to parse %s, no PEM encoded certificates found", CACertificateKey)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if hasCrt != hasKey {
+		return nil, fmt.Errorf("%s and %s must be set together", ClientCertificateKey, ClientKeyKey)
+	}
+
+	if hasCrt {
+		c, err := tls.X509KeyPair([]byte(crt), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate from %s and %s\n%w", ClientCertificateKey, ClientKeyKey, err)
+		}
+
+		config.Certificates = []tls.Certificate{c}
+	}
+
+	return config, nil
+}
+
+// CertificatesDigest returns the SHA256 digest of PEM encoded certificates, so that they can be recorded in layer
+// metadata.
+func CertificatesDigest(pem string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(pem)))
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func newCertificate() (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	k, err := x509.MarshalECPrivateKey(key)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: k}))
+}
+
+func testTLSConfig(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	it("returns nil without certificates", func() {
+		Expect(dt.TLSConfig(createBinding("api-token", "test-token"))).To(BeNil())
+	})
+
+	it("trusts ca.crt", func() {
+		server := ghttp.NewTLSServer()
+		defer server.Close()
+		server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{}))
+
+		ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.HTTPTestServer.Certificate().Raw}))
+
+		c, err := dt.TLSConfig(createBinding("ca.crt", ca))
+		Expect(err).NotTo(HaveOccurred())
+
+		var result map[string]interface{}
+		Expect(dt.NewClient(time.Second, 0, c).GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-token", "test-agent", &result)).
+			To(Succeed())
+		Expect(dt.NewClient(time.Second, 0, nil).GetJSON(fmt.Sprintf("%s/test", server.URL()), "test-token", "test-agent", &result)).
+			To(MatchError(ContainSubstring("certificate")))
+	})
+
+	it("loads client certificate", func() {
+		crt, key := newCertificate()
+
+		c, err := dt.TLSConfig(createBinding("tls.crt", crt, "tls.key", key))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Certificates).To(HaveLen(1))
+		Expect(c.RootCAs).To(BeNil())
+	})
+
+	it("fails with invalid ca.crt", func() {
+		_, err := dt.TLSConfig(createBinding("ca.crt", "test-ca"))
+		Expect(err).To(MatchError("unable to parse ca.crt, no PEM encoded certificates found"))
+	})
+
+	it("fails with tls.crt but no tls.key", func() {
+		crt, _ := newCertificate()
+
+		_, err := dt.TLSConfig(createBinding("tls.crt", crt))
+		Expect(err).To(MatchError("tls.crt and tls.key must be set together"))
+	})
+}
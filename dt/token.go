@@ -0,0 +1,100 @@
+/*
+ * Copyright 2018-2026 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+)
+
+// ScopeInstallerDownload is the PaaS integration scope required to list and download OneAgent versions and to request
+// the connection info.
+const ScopeInstallerDownload = "InstallerDownload"
+
+// RequiredScopes are the API token scopes the buildpack and its helper need.
+var RequiredScopes = []string{ScopeInstallerDownload}
+
+// TokenInfo is the metadata of an API token returned by the token lookup endpoint.
+type TokenInfo struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Enabled bool     `json:"enabled"`
+	Scopes  []string `json:"scopes"`
+}
+
+// MissingScopesError is returned when the API token lacks scopes the buildpack needs.
+type MissingScopesError struct {
+	Missing []string
+}
+
+func (e *MissingScopesError) Error() string {
+	return fmt.Sprintf("Dynatrace API token is missing the required scopes %s", strings.Join(e.Missing, ", "))
+}
+
+// LookupToken requests the metadata of the API token of the binding. The token authenticates the lookup of itself, so
+// no additional scope is needed.
+func LookupToken(client Client, binding libcnb.Binding, userAgent string) (TokenInfo, error) {
+	base, err := BaseURI(binding)
+	if err != nil {
+		return TokenInfo{}, fmt.Errorf("unable to determine Dynatrace API URL\n%w", err)
+	}
+
+	token := APIToken(binding)
+
+	var t TokenInfo
+	if err := client.PostJSON(fmt.Sprintf("%s/v2/apiTokens/lookup", base), token, userAgent,
+		map[string]string{"token": token}, &t); err != nil {
+		return TokenInfo{}, err
+	}
+
+	return t, nil
+}
+
+// CheckTokenScopes looks up the API token of the binding and returns a *MissingScopesError listing each of scopes the
+// token does not have, or an error if the token is disabled.
+func CheckTokenScopes(client Client, binding libcnb.Binding, userAgent string, scopes []string) error {
+	t, err := LookupToken(client, binding, userAgent)
+	if err != nil {
+		return err
+	}
+
+	if !t.Enabled {
+		return fmt.Errorf("Dynatrace API token %s is disabled", t.Name)
+	}
+
+	granted := make(map[string]bool)
+	for _, s := range t.Scopes {
+		granted[s] = true
+	}
+
+	var missing []string
+	for _, s := range scopes {
+		if !granted[s] {
+			missing = append(missing, s)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &MissingScopesError{Missing: missing}
+	}
+
+	return nil
+}
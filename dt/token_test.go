@@ -0,0 +1,133 @@
+/*
+ * Copyright 2018-2026 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testTokenScopes(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		binding libcnb.Binding
+		client  dt.Client
+		server  *ghttp.Server
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+		server = ghttp.NewServer()
+
+		binding = libcnb.Binding{
+			Name: "test-binding",
+			Type: "Dynatrace",
+			Secret: map[string]string{
+				"api-token": "test-api-token",
+				"api-url":   fmt.Sprintf("%s/api", server.URL()),
+			},
+		}
+
+		client = dt.NewClient(time.Second, 0, nil)
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("looks up the token with itself", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("POST", "/api/v2/apiTokens/lookup"),
+			ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
+			ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
+			ghttp.VerifyJSON(`{"token":"test-api-token"}`),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"id":      "test-id",
+				"name":    "test-name",
+				"enabled": true,
+				"scopes":  []string{"InstallerDownload", "DataExport"},
+			}),
+		))
+
+		info, err := dt.LookupToken(client, binding, "test-id/test-version")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info).To(Equal(dt.TokenInfo{
+			ID:      "test-id",
+			Name:    "test-name",
+			Enabled: true,
+			Scopes:  []string{"InstallerDownload", "DataExport"},
+		}))
+	})
+
+	it("passes with all scopes", func() {
+		server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+			"enabled": true,
+			"scopes":  []string{"InstallerDownload"},
+		}))
+
+		Expect(dt.CheckTokenScopes(client, binding, "test-id/test-version", dt.RequiredScopes)).To(Succeed())
+	})
+
+	it("reports exactly the missing scopes", func() {
+		server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+			"enabled": true,
+			"scopes":  []string{"test-scope-2"},
+		}))
+
+		err := dt.CheckTokenScopes(client, binding, "test-id/test-version", []string{"test-scope-3", "test-scope-2", "test-scope-1"})
+		Expect(err).To(MatchError("Dynatrace API token is missing the required scopes test-scope-1, test-scope-3"))
+
+		var missing *dt.MissingScopesError
+		Expect(errors.As(err, &missing)).To(BeTrue())
+		Expect(missing.Missing).To(Equal([]string{"test-scope-1", "test-scope-3"}))
+	})
+
+	it("fails on disabled token", func() {
+		server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+			"name":    "test-name",
+			"enabled": false,
+			"scopes":  []string{"InstallerDownload"},
+		}))
+
+		err := dt.CheckTokenScopes(client, binding, "test-id/test-version", dt.RequiredScopes)
+		Expect(err).To(MatchError("Dynatrace API token test-name is disabled"))
+	})
+
+	it("returns API error", func() {
+		server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusUnauthorized, map[string]interface{}{
+			"error": map[string]interface{}{"code": 401, "message": "Token test-api-token is invalid"},
+		}))
+
+		err := dt.CheckTokenScopes(client, binding, "test-id/test-version", dt.RequiredScopes)
+
+		var apiErr *dt.APIError
+		Expect(errors.As(err, &apiErr)).To(BeTrue())
+		Expect(apiErr.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(apiErr.Message).To(Equal("Token [REDACTED] is invalid"))
+	})
+}
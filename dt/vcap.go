@@ -0,0 +1,106 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/buildpacks/libcnb"
+)
+
+// vcapCredentialKeys maps the credential keys of the Cloud Foundry Dynatrace service to binding secret keys.
+var vcapCredentialKeys = map[string]string{
+	"apitoken":      "api-token",
+	"apiurl":        "api-url",
+	"environmentid": "environment-id",
+	"networkzone":   "network-zone",
+}
+
+type vcapService struct {
+	Name        string                 `json:"name"`
+	Label       string                 `json:"label"`
+	Tags        []string               `json:"tags"`
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+// VCAPServicesBindings returns the Dynatrace services in $VCAP_SERVICES, those with dynatrace in their label, name or
+// tags, as bindings of type Dynatrace. Their credentials are mapped to the secret keys BaseURI and APIToken consume.
+func VCAPServicesBindings() (libcnb.Bindings, error) {
+	s, ok := os.LookupEnv("VCAP_SERVICES")
+	if !ok || strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	raw := make(map[string][]vcapService)
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, fmt.Errorf("unable to decode $VCAP_SERVICES\n%w", err)
+	}
+
+	var labels []string
+	for l := range raw {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	var binds libcnb.Bindings
+	for _, l := range labels {
+		for _, service := range raw[l] {
+			if service.Label == "" {
+				service.Label = l
+			}
+			if !isDynatraceService(service) {
+				continue
+			}
+
+			secret := make(map[string]string)
+			for k, v := range service.Credentials {
+				if m, ok := vcapCredentialKeys[strings.ToLower(k)]; ok {
+					k = m
+				}
+
+				if s, ok := v.(string); ok {
+					secret[k] = s
+				} else {
+					secret[k] = fmt.Sprint(v)
+				}
+			}
+
+			binds = append(binds, libcnb.Binding{
+				Name:     service.Name,
+				Type:     "Dynatrace",
+				Provider: service.Label,
+				Secret:   secret,
+			})
+		}
+	}
+
+	return binds, nil
+}
+
+func isDynatraceService(service vcapService) bool {
+	for _, s := range append([]string{service.Label, service.Name}, service.Tags...) {
+		if strings.Contains(strings.ToLower(s), "dynatrace") {
+			return true
+		}
+	}
+
+	return false
+}
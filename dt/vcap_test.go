@@ -0,0 +1,109 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt_test
+
+import (
+	"testing"
+
+	"github.com/buildpacks/libcnb"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
+)
+
+func testVCAPServicesBindings(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	it("returns nothing without $VCAP_SERVICES", func() {
+		t.Setenv("VCAP_SERVICES", "")
+
+		Expect(dt.VCAPServicesBindings()).To(BeEmpty())
+	})
+
+	it("returns Dynatrace services", func() {
+		t.Setenv("VCAP_SERVICES", `{
+			"dynatrace": [{
+				"name": "test-dynatrace",
+				"label": "dynatrace",
+				"tags": [],
+				"credentials": {
+					"apiurl": "https://test.example.com/e/test-id/api",
+					"environmentid": "test-id",
+					"apitoken": "test-api-token",
+					"networkzone": "test-zone",
+					"skiperrors": true
+				}
+			}],
+			"user-provided": [{
+				"name": "test-tagged",
+				"label": "user-provided",
+				"tags": ["Dynatrace"],
+				"credentials": {"environmentid": "test-other-id", "apitoken": "test-other-token"}
+			}, {
+				"name": "test-database",
+				"label": "user-provided",
+				"tags": ["postgres"],
+				"credentials": {"uri": "postgres://localhost"}
+			}]
+		}`)
+
+		Expect(dt.VCAPServicesBindings()).To(Equal(libcnb.Bindings{
+			{
+				Name:     "test-dynatrace",
+				Type:     "Dynatrace",
+				Provider: "dynatrace",
+				Secret: map[string]string{
+					"api-url":        "https://test.example.com/e/test-id/api",
+					"environment-id": "test-id",
+					"api-token":      "test-api-token",
+					"network-zone":   "test-zone",
+					"skiperrors":     "true",
+				},
+			},
+			{
+				Name:     "test-tagged",
+				Type:     "Dynatrace",
+				Provider: "user-provided",
+				Secret: map[string]string{
+					"environment-id": "test-other-id",
+					"api-token":      "test-other-token",
+				},
+			},
+		}))
+	})
+
+	it("returns error for invalid $VCAP_SERVICES", func() {
+		t.Setenv("VCAP_SERVICES", "{")
+
+		_, err := dt.VCAPServicesBindings()
+		Expect(err).To(MatchError(ContainSubstring("unable to decode $VCAP_SERVICES")))
+	})
+
+	it("maps credentials for BaseURI and APIToken", func() {
+		t.Setenv("VCAP_SERVICES", `{"dynatrace": [{"name": "test-dynatrace", "credentials": {"environmentid": "test-id", "apitoken": "test-api-token"}}]}`)
+
+		binds, err := dt.VCAPServicesBindings()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binds).To(HaveLen(1))
+
+		Expect(dt.BaseURI(binds[0])).To(Equal("https://test-id.live.dynatrace.com/api"))
+		Expect(dt.APIToken(binds[0])).To(Equal("test-api-token"))
+	})
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DisableAgent returns the environment that keeps OneAgent from loading into the application by removing
+// liboneagentproc.so from $LD_PRELOAD, the -agentpath for liboneagentloader.so from $JAVA_TOOL_OPTIONS and the
+// --require of onenodeloader.js from $NODE_OPTIONS.
+func DisableAgent() map[string]string {
+	var kept []string
+	for _, l := range strings.FieldsFunc(os.Getenv("LD_PRELOAD"), func(r rune) bool {
+		return r == ':' || r == ' '
+	}) {
+		if filepath.Base(l) != "liboneagentproc.so" {
+			kept = append(kept, l)
+		}
+	}
+
+	e := map[string]string{"LD_PRELOAD": strings.Join(kept, string(os.PathListSeparator))}
+
+	if s, ok := os.LookupEnv("JAVA_TOOL_OPTIONS"); ok {
+		var options []string
+		for _, o := range strings.Fields(s) {
+			if !(strings.HasPrefix(o, "-agentpath:") && strings.Contains(o, "liboneagentloader.so")) {
+				options = append(options, o)
+			}
+		}
+
+		if j := strings.Join(options, " "); j != s {
+			e["JAVA_TOOL_OPTIONS"] = j
+		}
+	}
+
+	if s, ok := os.LookupEnv("NODE_OPTIONS"); ok {
+		var options []string
+		fields := strings.Fields(s)
+		for i := 0; i < len(fields); i++ {
+			if fields[i] == "--require" && i+1 < len(fields) && filepath.Base(fields[i+1]) == "onenodeloader.js" {
+				i++
+				continue
+			}
+			options = append(options, fields[i])
+		}
+
+		if n := strings.Join(options, " "); n != s {
+			e["NODE_OPTIONS"] = n
+		}
+	}
+
+	return e
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2018-2023 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/helper"
+)
+
+func testDisableAgent(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	it("removes OneAgent from $LD_PRELOAD", func() {
+		t.Setenv("LD_PRELOAD", "/layers/dynatrace-oneagent/agent/lib64/liboneagentproc.so:/layers/test/lib/other.so")
+
+		Expect(helper.DisableAgent()).To(Equal(map[string]string{
+			"LD_PRELOAD": "/layers/test/lib/other.so",
+		}))
+	})
+
+	it("removes OneAgent from $JAVA_TOOL_OPTIONS", func() {
+		t.Setenv("LD_PRELOAD", "")
+		t.Setenv("JAVA_TOOL_OPTIONS", "-Xmx1g -agentpath:/layers/dynatrace-oneagent/agent/lib64/liboneagentloader.so -Dtest=value")
+
+		Expect(helper.DisableAgent()).To(Equal(map[string]string{
+			"LD_PRELOAD":        "",
+			"JAVA_TOOL_OPTIONS": "-Xmx1g -Dtest=value",
+		}))
+	})
+
+	it("removes OneAgent from $NODE_OPTIONS", func() {
+		t.Setenv("LD_PRELOAD", "")
+		t.Setenv("NODE_OPTIONS", "--max-old-space-size=512 --require /layers/dynatrace-oneagent/agent/bin/any/onenodeloader.js")
+
+		Expect(helper.DisableAgent()).To(Equal(map[string]string{
+			"LD_PRELOAD":   "",
+			"NODE_OPTIONS": "--max-old-space-size=512",
+		}))
+	})
+
+	it("leaves other $JAVA_TOOL_OPTIONS alone", func() {
+		t.Setenv("LD_PRELOAD", "")
+		t.Setenv("JAVA_TOOL_OPTIONS", "-Xmx1g -agentpath:/layers/test/libother.so")
+
+		Expect(helper.DisableAgent()).To(Equal(map[string]string{
+			"LD_PRELOAD": "",
+		}))
+	})
+}
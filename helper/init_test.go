@@ -25,6 +25,8 @@ import (
 
 func TestUnit(t *testing.T) {
 	suite := spec.New("helper", spec.Report(report.Terminal{}))
+	suite("DisableAgent", testDisableAgent)
+	suite("KubernetesEnrichment", testKubernetesEnrichment)
 	suite("Properties", testProperties)
 	suite.Run(t)
 }
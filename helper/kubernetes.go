@@ -0,0 +1,159 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paketo-buildpacks/libpak/sherpa"
+)
+
+// MetadataAnnotationPrefix marks pod annotations that are passed to Dynatrace as metadata, with the prefix removed.
+const MetadataAnnotationPrefix = "metadata.dynatrace.com/"
+
+// KubernetesEnrichment returns the environment that enriches OneAgent with Kubernetes metadata read from the downward
+// API: $POD_NAME, $POD_UID, $POD_NAMESPACE and $NODE_NAME as well as the labels and annotations files in
+// $BPL_DYNATRACE_K8S_PODINFO. The metadata is appended to $DT_CUSTOM_PROP and $DT_TAGS, taken from e if set there.
+func (p Properties) KubernetesEnrichment(e map[string]string) map[string]string {
+	metadata := make(map[string]string)
+	for k, v := range map[string]string{
+		"k8s.pod.name":       "POD_NAME",
+		"k8s.pod.uid":        "POD_UID",
+		"k8s.namespace.name": "POD_NAMESPACE",
+		"k8s.node.name":      "NODE_NAME",
+	} {
+		if s := os.Getenv(v); s != "" {
+			metadata[k] = s
+		}
+	}
+
+	podinfo := sherpa.GetEnvWithDefault("BPL_DYNATRACE_K8S_PODINFO", "/etc/podinfo")
+
+	labels, err := readPodInfo(filepath.Join(podinfo, "labels"))
+	if err != nil {
+		p.Logger.Infof("Unable to read Kubernetes labels: %s", err)
+	}
+	for _, k := range []string{"app.kubernetes.io/name", "app"} {
+		if v, ok := labels[k]; ok {
+			metadata["k8s.workload.name"] = v
+			break
+		}
+	}
+
+	annotations, err := readPodInfo(filepath.Join(podinfo, "annotations"))
+	if err != nil {
+		p.Logger.Infof("Unable to read Kubernetes annotations: %s", err)
+	}
+	for k, v := range annotations {
+		if strings.HasPrefix(k, MetadataAnnotationPrefix) {
+			metadata[strings.TrimPrefix(k, MetadataAnnotationPrefix)] = v
+		}
+	}
+
+	if len(metadata) == 0 && len(labels) == 0 {
+		p.Logger.Info("No Kubernetes metadata found for Dynatrace enrichment")
+		return nil
+	}
+
+	p.Logger.Infof("Enriching Dynatrace with Kubernetes metadata of %d keys and %d labels", len(metadata), len(labels))
+
+	r := make(map[string]string)
+
+	var props []string
+	for _, k := range sortedKeys(metadata) {
+		props = append(props, fmt.Sprintf("%s=%s", k, sanitize(metadata[k])))
+	}
+	if len(props) > 0 {
+		r["DT_CUSTOM_PROP"] = join(current(e, "DT_CUSTOM_PROP"), props)
+	}
+
+	var tags []string
+	for _, k := range sortedKeys(labels) {
+		tags = append(tags, fmt.Sprintf("%s=%s", sanitize(k), sanitize(labels[k])))
+	}
+	if len(tags) > 0 {
+		r["DT_TAGS"] = join(current(e, "DT_TAGS"), tags)
+	}
+
+	return r
+}
+
+// readPodInfo reads a downward API file of key="value" lines. A missing file is not an error.
+func readPodInfo(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open %s\n%w", file, err)
+	}
+	defer f.Close()
+
+	m := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+
+		if u, err := strconv.Unquote(v); err == nil {
+			v = u
+		}
+		m[k] = v
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %s\n%w", file, err)
+	}
+
+	return m, nil
+}
+
+func current(e map[string]string, name string) string {
+	if s, ok := e[name]; ok {
+		return s
+	}
+	return os.Getenv(name)
+}
+
+func join(s string, values []string) string {
+	if s != "" {
+		values = append([]string{s}, values...)
+	}
+	return strings.Join(values, " ")
+}
+
+// sanitize replaces whitespace, which separates entries of $DT_CUSTOM_PROP and $DT_TAGS, with underscores.
+func sanitize(s string) string {
+	return strings.Join(strings.Fields(s), "_")
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
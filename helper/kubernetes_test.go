@@ -0,0 +1,68 @@
+/*
+ * Copyright 2018-2024 the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helper_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+
+	"github.com/paketo-buildpacks/dynatrace/v4/helper"
+)
+
+func testKubernetesEnrichment(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		p       helper.Properties
+		podinfo string
+	)
+
+	it.Before(func() {
+		podinfo = t.TempDir()
+
+		t.Setenv("BPL_DYNATRACE_K8S_PODINFO", podinfo)
+		t.Setenv("DT_CUSTOM_PROP", "CloudNativeBuildpackVersion=test-version")
+		t.Setenv("DT_TAGS", "")
+		for _, k := range []string{"POD_NAME", "POD_UID", "POD_NAMESPACE", "NODE_NAME"} {
+			t.Setenv(k, "")
+		}
+	})
+
+	it("returns nothing without metadata", func() {
+		Expect(p.KubernetesEnrichment(map[string]string{})).To(BeNil())
+	})
+
+	it("enriches with downward API metadata", func() {
+		t.Setenv("POD_NAME", "test-pod")
+		t.Setenv("POD_NAMESPACE", "test-namespace")
+		t.Setenv("NODE_NAME", "test-node")
+
+		Expect(os.WriteFile(filepath.Join(podinfo, "labels"), []byte(`app.kubernetes.io/name="test-app"
+team="test team"`), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(podinfo, "annotations"), []byte(`metadata.dynatrace.com/cost-center="test-cost-center"
+other="ignored"`), 0644)).To(Succeed())
+
+		Expect(p.KubernetesEnrichment(map[string]string{"DT_TAGS": "test-tag"})).To(Equal(map[string]string{
+			"DT_CUSTOM_PROP": "CloudNativeBuildpackVersion=test-version cost-center=test-cost-center k8s.namespace.name=test-namespace k8s.node.name=test-node k8s.pod.name=test-pod k8s.workload.name=test-app",
+			"DT_TAGS":        "test-tag app.kubernetes.io/name=test-app team=test_team",
+		}))
+	})
+}
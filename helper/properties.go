@@ -18,25 +18,49 @@ package helper
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/buildpacks/libcnb"
 	"github.com/paketo-buildpacks/libpak/bard"
-	"github.com/paketo-buildpacks/libpak/bindings"
+	"github.com/paketo-buildpacks/libpak/sherpa"
 
 	"github.com/paketo-buildpacks/dynatrace/v4/dt"
 )
 
+const (
+	FailurePolicyFail    = "fail"
+	FailurePolicyDisable = "disable"
+	FailurePolicyDegrade = "degrade"
+)
+
 type Properties struct {
 	Bindings libcnb.Bindings
 	Logger   bard.Logger
 }
 
 func (p Properties) Execute() (map[string]string, error) {
-	b, ok, err := bindings.ResolveOne(p.Bindings, dt.IsDynatraceBinding)
+	if _, ok := os.LookupEnv("BPL_DYNATRACE_ENABLED"); ok {
+		enabled, err := sherpa.ResolveBoolErr("BPL_DYNATRACE_ENABLED")
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve $BPL_DYNATRACE_ENABLED\n%w", err)
+		} else if !enabled {
+			p.Logger.Info("Dynatrace monitoring is disabled for this instance by $BPL_DYNATRACE_ENABLED, starting with OneAgent disabled")
+			return DisableAgent(), nil
+		}
+	}
+
+	// excluded process types start regardless of the binding
+	if types, ok := os.LookupEnv("BPI_DYNATRACE_PROCESS_TYPES"); ok && !instrumented(types) {
+		p.Logger.Info("Skipping Dynatrace properties, process type is not instrumented")
+		return nil, nil
+	}
+
+	b, ok, err := dt.ResolveBinding(p.Bindings, "BPL")
 	if err != nil {
 		return nil, fmt.Errorf("unable to resolve binding Dynatrace\n%w", err)
 	} else if !ok {
@@ -45,6 +69,18 @@ func (p Properties) Execute() (map[string]string, error) {
 
 	p.Logger.Info("Configuring Dynatrace properties")
 
+	policy := sherpa.GetEnvWithDefault("BPL_DYNATRACE_FAILURE_POLICY", FailurePolicyFail)
+	if policy != FailurePolicyFail && policy != FailurePolicyDisable && policy != FailurePolicyDegrade {
+		return nil, fmt.Errorf("unsupported $BPL_DYNATRACE_FAILURE_POLICY %s, must be one of %s, %s or %s",
+			policy, FailurePolicyFail, FailurePolicyDisable, FailurePolicyDegrade)
+	}
+
+	// an invalid binding is handled by the failure policy like a failure to get the connection info
+	invalid := dt.ValidateBinding(b)
+	if invalid != nil && policy == FailurePolicyFail {
+		return nil, invalid
+	}
+
 	id, ok := os.LookupEnv("BPI_DYNATRACE_BUILDPACK_ID")
 	if !ok {
 		return nil, fmt.Errorf("$BPI_DYNATRACE_BUILDPACK_ID must be set")
@@ -57,45 +93,69 @@ func (p Properties) Execute() (map[string]string, error) {
 
 	e := make(map[string]string)
 
-	uri := fmt.Sprintf("%s/v1/deployment/installer/agent/connectioninfo", dt.BaseURI(b))
+	zone := b.Secret["network-zone"]
+	if s, ok := os.LookupEnv("BPL_DYNATRACE_NETWORK_ZONE"); ok {
+		zone = s
+	}
 
-	req, err := http.NewRequest("GET", uri, nil)
+	tc, err := dt.TLSConfig(b)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create new GET request for %s\n%w", uri, err)
+		return nil, fmt.Errorf("unable to configure TLS for Dynatrace\n%w", err)
 	}
-	req.Header.Set("User-Agent", fmt.Sprintf("%s/%s", id, version))
-	req.Header.Set("Authorization", fmt.Sprintf("Api-Token %s", dt.APIToken(b)))
 
-	client := http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
-	resp, err := client.Do(req)
+	client, err := dt.NewClientFromEnvironment("BPL", 10, 30, tc)
 	if err != nil {
-		return nil, fmt.Errorf("unable to request %s\n%w", uri, err)
+		return nil, fmt.Errorf("unable to create Dynatrace client\n%w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return nil, fmt.Errorf("could not download %s: %d", uri, resp.StatusCode)
+	client.Logger = p.Logger
+
+	var c dt.ConnectionInfo
+	if invalid != nil {
+		err = invalid
+	} else {
+		ua := fmt.Sprintf("%s/%s", id, version)
+		if c, err = dt.GetConnectionInfo(client, b, ua, zone); err != nil {
+			err = p.DiagnoseToken(client, b, ua, err)
+		}
 	}
 
-	raw := struct {
-		Tenant                  string   `json:"tenantUUID"`
-		TenantToken             string   `json:"tenantToken"`
-		CommunicationsEndpoints []string `json:"communicationEndpoints"`
-	}{}
-
-	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
-		return nil, fmt.Errorf("unable to decode payload\n%w", err)
+	if err != nil {
+		if policy == FailurePolicyFail {
+			return nil, err
+		}
+
+		reason := strings.ReplaceAll(err.Error(), "\n", ": ")
+
+		var ok bool
+		if policy == FailurePolicyDegrade {
+			if c, ok = p.LastKnownConnectionInfo(); ok {
+				p.Logger.Infof("Unable to get Dynatrace connection info, starting with last-known connection info: %s", reason)
+			}
+		}
+
+		if !ok {
+			p.Logger.Infof("Unable to get Dynatrace connection info, starting with OneAgent disabled: %s", reason)
+			return DisableAgent(), nil
+		}
+	} else {
+		p.RememberConnectionInfo(c)
 	}
 
-	e["DT_TENANT"] = raw.Tenant
-	e["DT_TENANTTOKEN"] = raw.TenantToken
-	e["DT_CONNECTION_POINT"] = strings.Join(raw.CommunicationsEndpoints, ";")
+	for k, v := range c.Environment() {
+		e[k] = v
+	}
 
 	delete(b.Secret, "api-token")
 	delete(b.Secret, "apitoken")
 	delete(b.Secret, "api-url")
 	delete(b.Secret, "apiurl")
 	delete(b.Secret, "environment-id")
+	delete(b.Secret, "managed-domain")
+	delete(b.Secret, "saas-domain")
+	delete(b.Secret, "network-zone")
+	delete(b.Secret, dt.CACertificateKey)
+	delete(b.Secret, dt.ClientCertificateKey)
+	delete(b.Secret, dt.ClientKeyKey)
 
 	for k, v := range b.Secret {
 		s := strings.ToUpper(k)
@@ -105,5 +165,89 @@ func (p Properties) Execute() (map[string]string, error) {
 		e[fmt.Sprintf("DT_%s", s)] = v
 	}
 
+	if sherpa.ResolveBool("BPL_DYNATRACE_K8S_ENRICHMENT") {
+		for k, v := range p.KubernetesEnrichment(e) {
+			e[k] = v
+		}
+	}
+
 	return e, nil
 }
+
+// instrumented reports whether the current process is one of the instrumented process types. The agent environment,
+// including $BPI_DYNATRACE_BUILDPACK_ID, is only written for instrumented process types.
+func instrumented(types string) bool {
+	if t, ok := os.LookupEnv("CNB_PROCESS_TYPE"); ok {
+		for _, s := range strings.Split(types, ",") {
+			if strings.TrimSpace(s) == t {
+				return true
+			}
+		}
+		return false
+	}
+
+	_, ok := os.LookupEnv("BPI_DYNATRACE_BUILDPACK_ID")
+	return ok
+}
+
+// DiagnoseToken adds the scopes missing from the API token to err if the tenant denied the request. The token is only
+// looked up after such a failure so that a healthy start does not pay for an additional request.
+func (p Properties) DiagnoseToken(client dt.Client, binding libcnb.Binding, userAgent string, err error) error {
+	var apiErr *dt.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		return err
+	}
+
+	var missing *dt.MissingScopesError
+	if scopeErr := dt.CheckTokenScopes(client, binding, userAgent, dt.RequiredScopes); errors.As(scopeErr, &missing) {
+		return fmt.Errorf("%w\n%s", err, missing)
+	}
+
+	return err
+}
+
+// LastKnownConnectionInfo returns the connection info remembered by a previous start or, failing that, embedded at
+// build time.
+func (p Properties) LastKnownConnectionInfo() (dt.ConnectionInfo, bool) {
+	if b, err := os.ReadFile(lastKnownFile()); err == nil {
+		var c dt.ConnectionInfo
+		if err := json.Unmarshal(b, &c); err == nil {
+			return c, true
+		}
+	}
+
+	tenant, ok := os.LookupEnv("DT_TENANT")
+	if !ok || tenant == "" {
+		return dt.ConnectionInfo{}, false
+	}
+
+	c := dt.ConnectionInfo{
+		TenantUUID:  tenant,
+		TenantToken: os.Getenv("DT_TENANTTOKEN"),
+		NetworkZone: os.Getenv("DT_NETWORK_ZONE"),
+	}
+	if s := os.Getenv("DT_CONNECTION_POINT"); s != "" {
+		c.CommunicationEndpoints = strings.Split(s, ";")
+	}
+
+	return c, true
+}
+
+// RememberConnectionInfo stores the connection info for LastKnownConnectionInfo. Failures are logged rather than
+// returned as the application can still start.
+func (p Properties) RememberConnectionInfo(c dt.ConnectionInfo) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+
+	if err := os.WriteFile(lastKnownFile(), b, 0600); err != nil {
+		p.Logger.Infof("Unable to remember Dynatrace connection info: %s", err)
+	}
+}
+
+// lastKnownFile returns the file of the remembered connection info in $BPL_DYNATRACE_STATE_DIR, which defaults to the
+// temporary directory as the layers are read-only at launch.
+func lastKnownFile() string {
+	return filepath.Join(sherpa.GetEnvWithDefault("BPL_DYNATRACE_STATE_DIR", os.TempDir()), "dynatrace-connection-info.json")
+}
@@ -17,8 +17,11 @@
 package helper_test
 
 import (
+	"encoding/pem"
+	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/buildpacks/libcnb"
@@ -26,6 +29,7 @@ import (
 	"github.com/onsi/gomega/ghttp"
 	"github.com/sclevine/spec"
 
+	"github.com/paketo-buildpacks/dynatrace/v4/dt"
 	"github.com/paketo-buildpacks/dynatrace/v4/helper"
 )
 
@@ -33,13 +37,16 @@ func testProperties(t *testing.T, context spec.G, it spec.S) {
 	var (
 		Expect = NewWithT(t).Expect
 
+		ca     string
 		p      helper.Properties
 		server *ghttp.Server
 	)
 
 	it.Before(func() {
 		RegisterTestingT(t)
-		server = ghttp.NewServer()
+		server = ghttp.NewTLSServer()
+		ca = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.HTTPTestServer.Certificate().Raw}))
+		t.Setenv("BPL_DYNATRACE_STATE_DIR", t.TempDir())
 	})
 
 	it.After(func() {
@@ -50,6 +57,101 @@ func testProperties(t *testing.T, context spec.G, it spec.S) {
 		Expect(p.Execute()).To(BeNil())
 	})
 
+	it("uses Dynatrace service from $VCAP_SERVICES", func() {
+		t.Setenv("VCAP_SERVICES", fmt.Sprintf(`{"dynatrace": [{"name": "test-service", "credentials": {"apiurl": %q, "apitoken": "test-api-token", "ca.crt": %q}}]}`, server.URL(), ca))
+		t.Setenv("BPI_DYNATRACE_BUILDPACK_ID", "test-id")
+		t.Setenv("BPI_DYNATRACE_BUILDPACK_VERSION", "test-version")
+
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/connectioninfo"),
+			ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"tenantUUID":             "test-tenant-uuid",
+				"tenantToken":            "test-tenant-token",
+				"communicationEndpoints": []string{"test-communication-endpoint-1"},
+			}),
+		))
+
+		Expect(p.Execute()).To(Equal(map[string]string{
+			"DT_CONNECTION_POINT": "test-communication-endpoint-1",
+			"DT_TENANT":           "test-tenant-uuid",
+			"DT_TENANTTOKEN":      "test-tenant-token",
+		}))
+	})
+
+	it("uses credentials from the environment", func() {
+		t.Setenv("BPL_DYNATRACE_API_URL", server.URL())
+		t.Setenv("BPL_DYNATRACE_API_TOKEN", "test-api-token")
+		t.Setenv("BPI_DYNATRACE_BUILDPACK_ID", "test-id")
+		t.Setenv("BPI_DYNATRACE_BUILDPACK_VERSION", "test-version")
+		t.Setenv("BPL_DYNATRACE_CA_CERT", ca)
+
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/connectioninfo"),
+			ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"tenantUUID":             "test-tenant-uuid",
+				"tenantToken":            "test-tenant-token",
+				"communicationEndpoints": []string{"test-communication-endpoint-1"},
+			}),
+		))
+
+		Expect(p.Execute()).To(Equal(map[string]string{
+			"DT_CONNECTION_POINT": "test-communication-endpoint-1",
+			"DT_TENANT":           "test-tenant-uuid",
+			"DT_TENANTTOKEN":      "test-tenant-token",
+		}))
+	})
+
+	context("$BPL_DYNATRACE_ENABLED", func() {
+		it.Before(func() {
+			t.Setenv("LD_PRELOAD", "/layers/dynatrace-oneagent/agent/lib64/liboneagentproc.so:/layers/test/lib/other.so")
+			p.Bindings = libcnb.Bindings{
+				{
+					Name: "test-binding",
+					Type: "Dynatrace",
+					Secret: map[string]string{
+						"api-token": "test-api-token",
+						"api-url":   server.URL(),
+						"ca.crt":    ca,
+					},
+				},
+			}
+		})
+
+		it("disables agent", func() {
+			t.Setenv("BPL_DYNATRACE_ENABLED", "false")
+
+			Expect(p.Execute()).To(Equal(map[string]string{
+				"LD_PRELOAD": "/layers/test/lib/other.so",
+			}))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		it("disables agent without binding", func() {
+			t.Setenv("BPL_DYNATRACE_ENABLED", "false")
+			p.Bindings = nil
+
+			Expect(p.Execute()).To(Equal(map[string]string{
+				"LD_PRELOAD": "/layers/test/lib/other.so",
+			}))
+		})
+
+		it("configures agent if enabled", func() {
+			t.Setenv("BPL_DYNATRACE_ENABLED", "true")
+
+			_, err := p.Execute()
+			Expect(err).To(MatchError("$BPI_DYNATRACE_BUILDPACK_ID must be set"))
+		})
+
+		it("returns error on invalid value", func() {
+			t.Setenv("BPL_DYNATRACE_ENABLED", "test-value")
+
+			_, err := p.Execute()
+			Expect(err).To(MatchError(ContainSubstring("invalid value 'test-value' for key 'BPL_DYNATRACE_ENABLED'")))
+		})
+	})
+
 	context("with binding", func() {
 		it.Before(func() {
 			p.Bindings = libcnb.Bindings{
@@ -59,17 +161,83 @@ func testProperties(t *testing.T, context spec.G, it spec.S) {
 					Secret: map[string]string{
 						"api-token": "test-api-token",
 						"api-url":   server.URL(),
+						"ca.crt":    ca,
 						"test-key":  "test-value",
 					},
 				},
 			}
 		})
 
+		it("returns error if multiple bindings exist", func() {
+			p.Bindings = append(p.Bindings, libcnb.Binding{Name: "shared-dynatrace-config", Type: "user-provided"})
+
+			_, err := p.Execute()
+			Expect(err).To(MatchError(ContainSubstring("select one with $BPL_DYNATRACE_BINDING_NAME")))
+		})
+
+		it("selects binding with $BPL_DYNATRACE_BINDING_NAME", func() {
+			t.Setenv("BPL_DYNATRACE_BINDING_NAME", "test-binding")
+			p.Bindings = append(p.Bindings, libcnb.Binding{Name: "shared-dynatrace-config", Type: "user-provided"})
+
+			_, err := p.Execute()
+			Expect(err).To(MatchError("$BPI_DYNATRACE_BUILDPACK_ID must be set"))
+		})
+
+		it("returns error if binding is invalid", func() {
+			delete(p.Bindings[0].Secret, "api-token")
+
+			_, err := p.Execute()
+			Expect(err).To(MatchError(ContainSubstring("api-token must be set")))
+		})
+
 		it("returns error if $BPI_DYNATRACE_BUILDPACK_ID is not set", func() {
 			_, err := p.Execute()
 			Expect(err).To(MatchError("$BPI_DYNATRACE_BUILDPACK_ID must be set"))
 		})
 
+		context("$BPI_DYNATRACE_PROCESS_TYPES", func() {
+			it.Before(func() {
+				t.Setenv("BPI_DYNATRACE_PROCESS_TYPES", "api,web")
+			})
+
+			it("skips process types without agent environment", func() {
+				Expect(p.Execute()).To(BeNil())
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+
+			it("skips excluded $CNB_PROCESS_TYPE", func() {
+				t.Setenv("CNB_PROCESS_TYPE", "worker")
+				t.Setenv("BPI_DYNATRACE_BUILDPACK_ID", "test-id")
+
+				Expect(p.Execute()).To(BeNil())
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+
+			it("skips excluded $CNB_PROCESS_TYPE with ambiguous bindings", func() {
+				t.Setenv("CNB_PROCESS_TYPE", "worker")
+				t.Setenv("BPI_DYNATRACE_BUILDPACK_ID", "test-id")
+				p.Bindings = append(p.Bindings, libcnb.Binding{Name: "shared-dynatrace-config", Type: "user-provided"})
+
+				Expect(p.Execute()).To(BeNil())
+			})
+
+			it("skips excluded $CNB_PROCESS_TYPE with malformed $VCAP_SERVICES", func() {
+				t.Setenv("CNB_PROCESS_TYPE", "worker")
+				t.Setenv("BPI_DYNATRACE_BUILDPACK_ID", "test-id")
+				t.Setenv("VCAP_SERVICES", "{")
+				p.Bindings = nil
+
+				Expect(p.Execute()).To(BeNil())
+			})
+
+			it("configures included $CNB_PROCESS_TYPE", func() {
+				t.Setenv("CNB_PROCESS_TYPE", "web")
+
+				_, err := p.Execute()
+				Expect(err).To(MatchError("$BPI_DYNATRACE_BUILDPACK_ID must be set"))
+			})
+		})
+
 		context("$BPI_DYNATRACE_BUILDPACK_ID", func() {
 			it.Before(func() {
 				Expect(os.Setenv("BPI_DYNATRACE_BUILDPACK_ID", "test-id")).To(Succeed())
@@ -95,7 +263,7 @@ func testProperties(t *testing.T, context spec.G, it spec.S) {
 
 				it("contributes properties if binding exists", func() {
 					server.AppendHandlers(ghttp.CombineHandlers(
-						ghttp.VerifyRequest("GET", "/v1/deployment/installer/agent/connectioninfo"),
+						ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/connectioninfo"),
 						ghttp.VerifyHeaderKV("Authorization", "Api-Token test-api-token"),
 						ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
 						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
@@ -113,6 +281,190 @@ func testProperties(t *testing.T, context spec.G, it spec.S) {
 					}))
 				})
 
+				it("enriches with Kubernetes metadata if $BPL_DYNATRACE_K8S_ENRICHMENT is set", func() {
+					t.Setenv("BPL_DYNATRACE_K8S_ENRICHMENT", "true")
+					t.Setenv("BPL_DYNATRACE_K8S_PODINFO", t.TempDir())
+					t.Setenv("DT_CUSTOM_PROP", "")
+					t.Setenv("POD_NAME", "test-pod")
+
+					server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"tenantUUID":             "test-tenant-uuid",
+						"tenantToken":            "test-tenant-token",
+						"communicationEndpoints": []string{"test-communication-endpoint-1"},
+					}))
+
+					Expect(p.Execute()).To(HaveKeyWithValue("DT_CUSTOM_PROP", "k8s.pod.name=test-pod"))
+				})
+
+				context("$BPL_DYNATRACE_FAILURE_POLICY", func() {
+					it.Before(func() {
+						t.Setenv("LD_PRELOAD", "/layers/test/lib/other.so:/layers/dynatrace-oneagent/agent/lib64/liboneagentproc.so")
+						server.AppendHandlers(ghttp.RespondWith(http.StatusUnauthorized, ""))
+					})
+
+					it("fails by default", func() {
+						_, err := p.Execute()
+						Expect(err).To(MatchError(ContainSubstring("failed: 401")))
+					})
+
+					it("fails on unsupported policy", func() {
+						t.Setenv("BPL_DYNATRACE_FAILURE_POLICY", "test-policy")
+
+						_, err := p.Execute()
+						Expect(err).To(MatchError(ContainSubstring("unsupported $BPL_DYNATRACE_FAILURE_POLICY test-policy")))
+					})
+
+					it("disables agent", func() {
+						t.Setenv("BPL_DYNATRACE_FAILURE_POLICY", "disable")
+
+						Expect(p.Execute()).To(Equal(map[string]string{
+							"LD_PRELOAD": "/layers/test/lib/other.so",
+						}))
+					})
+
+					it("degrades to remembered connection info", func() {
+						state := t.TempDir()
+						t.Setenv("BPL_DYNATRACE_STATE_DIR", state)
+						t.Setenv("BPL_DYNATRACE_FAILURE_POLICY", "degrade")
+						Expect(os.WriteFile(filepath.Join(state, "dynatrace-connection-info.json"), []byte(`{
+							"tenantUUID": "last-tenant-uuid",
+							"tenantToken": "last-tenant-token",
+							"communicationEndpoints": ["last-communication-endpoint"]
+						}`), 0600)).To(Succeed())
+
+						Expect(p.Execute()).To(Equal(map[string]string{
+							"DT_CONNECTION_POINT": "last-communication-endpoint",
+							"DT_TENANT":           "last-tenant-uuid",
+							"DT_TENANTTOKEN":      "last-tenant-token",
+							"DT_TEST_KEY":         "test-value",
+						}))
+					})
+
+					it("degrades to embedded connection info", func() {
+						t.Setenv("BPL_DYNATRACE_FAILURE_POLICY", "degrade")
+						t.Setenv("DT_TENANT", "embedded-tenant-uuid")
+						t.Setenv("DT_TENANTTOKEN", "embedded-tenant-token")
+						t.Setenv("DT_CONNECTION_POINT", "embedded-communication-endpoint")
+
+						Expect(p.Execute()).To(Equal(map[string]string{
+							"DT_CONNECTION_POINT": "embedded-communication-endpoint",
+							"DT_TENANT":           "embedded-tenant-uuid",
+							"DT_TENANTTOKEN":      "embedded-tenant-token",
+							"DT_TEST_KEY":         "test-value",
+						}))
+					})
+
+					it("disables agent on invalid binding", func() {
+						t.Setenv("BPL_DYNATRACE_FAILURE_POLICY", "disable")
+						p.Bindings[0].Secret["api_token"] = "test-api-token"
+
+						Expect(p.Execute()).To(Equal(map[string]string{
+							"LD_PRELOAD": "/layers/test/lib/other.so",
+						}))
+						Expect(server.ReceivedRequests()).To(BeEmpty())
+					})
+
+					it("degrades to embedded connection info on invalid binding", func() {
+						t.Setenv("BPL_DYNATRACE_FAILURE_POLICY", "degrade")
+						t.Setenv("DT_TENANT", "embedded-tenant-uuid")
+						t.Setenv("DT_TENANTTOKEN", "embedded-tenant-token")
+						t.Setenv("DT_CONNECTION_POINT", "embedded-communication-endpoint")
+						p.Bindings[0].Secret["api_token"] = "test-api-token"
+
+						Expect(p.Execute()).To(HaveKeyWithValue("DT_TENANT", "embedded-tenant-uuid"))
+						Expect(server.ReceivedRequests()).To(BeEmpty())
+					})
+
+					it("fails on invalid binding by default", func() {
+						p.Bindings[0].Secret["api_token"] = "test-api-token"
+
+						_, err := p.Execute()
+						Expect(err).To(MatchError(ContainSubstring("unknown key api_token, did you mean api-token?")))
+					})
+
+					it("disables agent when degrading without connection info", func() {
+						t.Setenv("BPL_DYNATRACE_FAILURE_POLICY", "degrade")
+
+						Expect(p.Execute()).To(Equal(map[string]string{
+							"LD_PRELOAD": "/layers/test/lib/other.so",
+						}))
+					})
+				})
+
+				it("reports missing token scopes when forbidden", func() {
+					server.AppendHandlers(
+						ghttp.RespondWithJSONEncoded(http.StatusForbidden, map[string]interface{}{
+							"error": map[string]interface{}{"code": 403, "message": "Token is missing required scope"},
+						}),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("POST", "/api/v2/apiTokens/lookup"),
+							ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+								"enabled": true,
+								"scopes":  []string{"DataExport"},
+							}),
+						),
+					)
+
+					_, err := p.Execute()
+					Expect(err).To(MatchError(ContainSubstring("403: Token is missing required scope")))
+					Expect(err).To(MatchError(ContainSubstring("Dynatrace API token is missing the required scopes InstallerDownload")))
+				})
+
+				it("remembers connection info", func() {
+					state := t.TempDir()
+					t.Setenv("BPL_DYNATRACE_STATE_DIR", state)
+
+					server.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+						"tenantUUID":             "test-tenant-uuid",
+						"tenantToken":            "test-tenant-token",
+						"communicationEndpoints": []string{"test-communication-endpoint-1"},
+					}))
+
+					_, err := p.Execute()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(filepath.Join(state, "dynatrace-connection-info.json")).To(BeARegularFile())
+
+					c, ok := p.LastKnownConnectionInfo()
+					Expect(ok).To(BeTrue())
+					Expect(c).To(Equal(dt.ConnectionInfo{
+						TenantUUID:             "test-tenant-uuid",
+						TenantToken:            "test-tenant-token",
+						CommunicationEndpoints: []string{"test-communication-endpoint-1"},
+					}))
+				})
+
+				context("network zone", func() {
+					it.Before(func() {
+						server.AppendHandlers(ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/connectioninfo", "networkZone=test-zone"),
+							ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+								"tenantUUID":             "test-tenant-uuid",
+								"tenantToken":            "test-tenant-token",
+								"communicationEndpoints": []string{"test-communication-endpoint-1"},
+							}),
+						))
+					})
+
+					it("uses network-zone from binding", func() {
+						p.Bindings[0].Secret["network-zone"] = "test-zone"
+
+						Expect(p.Execute()).To(Equal(map[string]string{
+							"DT_CONNECTION_POINT": "test-communication-endpoint-1",
+							"DT_NETWORK_ZONE":     "test-zone",
+							"DT_TENANT":           "test-tenant-uuid",
+							"DT_TENANTTOKEN":      "test-tenant-token",
+							"DT_TEST_KEY":         "test-value",
+						}))
+					})
+
+					it("uses $BPL_DYNATRACE_NETWORK_ZONE over binding", func() {
+						p.Bindings[0].Secret["network-zone"] = "other-zone"
+						t.Setenv("BPL_DYNATRACE_NETWORK_ZONE", "test-zone")
+
+						Expect(p.Execute()).To(HaveKeyWithValue("DT_NETWORK_ZONE", "test-zone"))
+					})
+				})
+
 				it("contributes properties if named binding exists", func() {
 					p.Bindings = libcnb.Bindings{
 						{
@@ -121,13 +473,14 @@ func testProperties(t *testing.T, context spec.G, it spec.S) {
 							Secret: map[string]string{
 								"api-token": "custom-test-api-token",
 								"api-url":   server.URL(),
+								"ca.crt":    ca,
 								"test-key":  "custom-test-value",
 							},
 						},
 					}
 
 					server.AppendHandlers(ghttp.CombineHandlers(
-						ghttp.VerifyRequest("GET", "/v1/deployment/installer/agent/connectioninfo"),
+						ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/connectioninfo"),
 						ghttp.VerifyHeaderKV("Authorization", "Api-Token custom-test-api-token"),
 						ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
 						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
@@ -153,13 +506,14 @@ func testProperties(t *testing.T, context spec.G, it spec.S) {
 							Secret: map[string]string{
 								"apitoken": "custom-test-apitoken",
 								"apiurl":   server.URL(),
+								"ca.crt":   ca,
 								"test-key": "custom-test-value",
 							},
 						},
 					}
 
 					server.AppendHandlers(ghttp.CombineHandlers(
-						ghttp.VerifyRequest("GET", "/v1/deployment/installer/agent/connectioninfo"),
+						ghttp.VerifyRequest("GET", "/api/v1/deployment/installer/agent/connectioninfo"),
 						ghttp.VerifyHeaderKV("Authorization", "Api-Token custom-test-apitoken"),
 						ghttp.VerifyHeaderKV("User-Agent", "test-id/test-version"),
 						ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
@@ -2,17 +2,21 @@
 set -euo pipefail
 
 GOMOD=$(head -1 go.mod | awk '{print $2}')
+GOOS="linux" GOARCH="amd64" go build -ldflags='-s -w' -o "linux/amd64/bin/doctor" "$GOMOD/cmd/doctor"
+GOOS="linux" GOARCH="arm64" go build -ldflags='-s -w' -o "linux/arm64/bin/doctor" "$GOMOD/cmd/doctor"
 GOOS="linux" GOARCH="amd64" go build -ldflags='-s -w' -o "linux/amd64/bin/helper" "$GOMOD/cmd/helper"
 GOOS="linux" GOARCH="arm64" go build -ldflags='-s -w' -o "linux/arm64/bin/helper" "$GOMOD/cmd/helper"
 GOOS="linux" GOARCH="amd64" go build -ldflags='-s -w' -o "linux/amd64/bin/main" "$GOMOD/cmd/main"
 GOOS="linux" GOARCH="arm64" go build -ldflags='-s -w' -o "linux/arm64/bin/main" "$GOMOD/cmd/main"
 
 if [ "${STRIP:-false}" != "false" ]; then
+  strip linux/amd64/bin/doctor linux/arm64/bin/doctor
   strip linux/amd64/bin/helper linux/arm64/bin/helper
   strip linux/amd64/bin/main linux/arm64/bin/main
 fi
 
 if [ "${COMPRESS:-none}" != "none" ]; then
+  $COMPRESS linux/amd64/bin/doctor linux/arm64/bin/doctor
   $COMPRESS linux/amd64/bin/helper linux/arm64/bin/helper
   $COMPRESS linux/amd64/bin/main linux/arm64/bin/main
 fi